@@ -0,0 +1,180 @@
+package skynet
+
+import (
+	"fmt"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// BatchItem is a single upload of UploadBatch. If Path is set, the file at
+	// Path is uploaded like with UploadFile. Otherwise the embedded entry is
+	// uploaded as a single file like with UploadEntries.
+	BatchItem struct {
+		// Path is the path of a local file to upload.
+		Path string
+
+		UploadEntry
+	}
+
+	// BatchUploadOptions contains the options used for batch uploads.
+	BatchUploadOptions struct {
+		// UploadOptions are the options used for every item. Progress, if
+		// set, is called for every item and may be called concurrently.
+		UploadOptions
+
+		// Workers is the maximum number of items uploaded at the same time.
+		// Values lower than 1 are treated as 1.
+		Workers int
+		// FailFast stops starting new uploads after the first failure. The
+		// uploads that were not started are reported as skipped, while the
+		// uploads that are already in progress are not cancelled and are
+		// reported with their own results. Otherwise all items are uploaded
+		// regardless of failures.
+		FailFast bool
+		// OnResult, if set, is called with the result of every item as soon
+		// as it is known. It is never called concurrently.
+		OnResult BatchResultFunc
+	}
+
+	// BatchResult contains the result of a single item of a batch upload.
+	BatchResult struct {
+		// Index is the index of the item in the batch.
+		Index int
+		// Skylink is the skylink of the item if the upload succeeded.
+		Skylink string
+		// Err is the error of the upload, or ErrBatchItemSkipped if the
+		// upload was not started.
+		Err error
+	}
+
+	// BatchResultFunc is a callback that receives the results of a batch
+	// upload.
+	BatchResultFunc func(BatchResult)
+
+	// BatchSummary contains the results of a batch upload.
+	BatchSummary struct {
+		// Results contains the result of every item, in the order of the
+		// items.
+		Results []BatchResult
+
+		// Succeeded is the number of items that were uploaded.
+		Succeeded int
+		// Failed is the number of items whose upload failed.
+		Failed int
+		// Skipped is the number of items that were not uploaded because an
+		// earlier upload failed in fail-fast mode.
+		Skipped int
+	}
+)
+
+var (
+	// DefaultBatchUploadOptions contains the default batch upload options.
+	DefaultBatchUploadOptions = BatchUploadOptions{
+		UploadOptions: DefaultUploadOptions,
+
+		Workers:  4,
+		FailFast: false,
+		OnResult: nil,
+	}
+
+	// ErrBatchFailed is returned by UploadBatch if any item failed.
+	ErrBatchFailed = errors.New("batch upload failed")
+	// ErrBatchItemSkipped is the error of the items that were not uploaded
+	// because an earlier upload failed in fail-fast mode.
+	ErrBatchItemSkipped = errors.New("upload skipped after an earlier failure")
+)
+
+// UploadFiles uploads the files at the given paths with UploadBatch.
+func (sc *SkynetClient) UploadFiles(paths []string, opts BatchUploadOptions) (BatchSummary, error) {
+	items := make([]BatchItem, 0, len(paths))
+	for _, path := range paths {
+		items = append(items, BatchItem{Path: path})
+	}
+	return sc.UploadBatch(items, opts)
+}
+
+// UploadBatch uploads every item as a separate skyfile, using up to
+// opts.Workers concurrent uploads. The summary contains the result of every
+// item. The returned error wraps ErrBatchFailed and the first failure if any
+// item failed.
+func (sc *SkynetClient) UploadBatch(items []BatchItem, opts BatchUploadOptions) (BatchSummary, error) {
+	summary := BatchSummary{Results: make([]BatchResult, len(items))}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	report := func(result BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Results[result.Index] = result
+		if result.Err != nil && firstErr == nil {
+			firstErr = errors.AddContext(result.Err, fmt.Sprintf("could not upload item %v", result.Index))
+		}
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	// Start an upload whenever a worker is free until the items run out, or
+	// until an upload failed in fail-fast mode. Waiting for a free worker
+	// before checking for failures makes sure that no upload is started
+	// after a failure was reported.
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	next := 0
+	for ; next < len(items); next++ {
+		sem <- struct{}{}
+		if opts.FailFast && failed() {
+			break
+		}
+		wg.Add(1)
+		go func(index int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			skylink, err := sc.uploadBatchItem(items[index], opts.UploadOptions)
+			report(BatchResult{Index: index, Skylink: skylink, Err: err})
+		}(next)
+	}
+	wg.Wait()
+	for index := next; index < len(items); index++ {
+		report(BatchResult{Index: index, Err: ErrBatchItemSkipped})
+	}
+
+	for _, result := range summary.Results {
+		switch {
+		case result.Err == nil:
+			summary.Succeeded++
+		case result.Err == ErrBatchItemSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	if firstErr != nil {
+		return summary, errors.Compose(ErrBatchFailed, firstErr)
+	}
+	return summary, nil
+}
+
+// uploadBatchItem uploads a single item of a batch upload.
+func (sc *SkynetClient) uploadBatchItem(item BatchItem, opts UploadOptions) (string, error) {
+	if item.Path != "" {
+		return sc.UploadFile(item.Path, opts)
+	}
+	return sc.UploadEntries([]UploadEntry{item.UploadEntry}, opts)
+}
@@ -14,6 +14,9 @@ type (
 	SkynetClient struct {
 		PortalURL string
 		Options   Options
+
+		// revisions caches the latest known revisions of registry entries.
+		revisions *revisionCache
 	}
 
 	// requestOptions contains the options for a request.
@@ -24,6 +27,15 @@ type (
 		reqBody   io.Reader
 		extraPath string
 		query     url.Values
+
+		// contentLength is the length of reqBody. It only needs to be set if
+		// http.NewRequest can't infer it from reqBody.
+		contentLength int64
+		// headers contains extra headers to set on the request.
+		headers http.Header
+		// rawURL, if set, is requested instead of the URL made from the portal
+		// URL and the endpoint path.
+		rawURL string
 	}
 )
 
@@ -41,6 +53,7 @@ func NewCustom(portalURL string, customOptions Options) SkynetClient {
 	return SkynetClient{
 		PortalURL: portalURL,
 		Options:   customOptions,
+		revisions: newRevisionCache(),
 	}
 }
 
@@ -58,6 +71,9 @@ func (sc *SkynetClient) executeRequest(config requestOptions) (*http.Response, e
 	if config.APIKey != "" {
 		opts.APIKey = config.APIKey
 	}
+	if config.Keystore != nil {
+		opts.Keystore = config.Keystore
+	}
 	if config.CustomUserAgent != "" {
 		opts.CustomUserAgent = config.CustomUserAgent
 	}
@@ -66,13 +82,26 @@ func (sc *SkynetClient) executeRequest(config requestOptions) (*http.Response, e
 	}
 
 	// Make the URL.
-	url = makeURL(url, opts.EndpointPath, config.extraPath, config.query)
+	if config.rawURL != "" {
+		url = config.rawURL
+	} else {
+		url = makeURL(url, opts.EndpointPath, config.extraPath, config.query)
+	}
 
 	// Create the request.
 	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
 		return nil, errors.AddContext(err, fmt.Sprintf("could not create %v request", method))
 	}
+	if config.contentLength > 0 {
+		req.ContentLength = config.contentLength
+	}
+	if opts.APIKey == "" && opts.Keystore != nil {
+		opts.APIKey, err = opts.Keystore.APIKey(sc.PortalURL)
+		if err != nil && !errors.Contains(err, ErrKeyNotFound) {
+			return nil, errors.AddContext(err, "could not get API key from keystore")
+		}
+	}
 	if opts.APIKey != "" {
 		req.SetBasicAuth("", opts.APIKey)
 	}
@@ -82,6 +111,11 @@ func (sc *SkynetClient) executeRequest(config requestOptions) (*http.Response, e
 	if opts.customContentType != "" {
 		req.Header.Set("Content-Type", opts.customContentType)
 	}
+	for key, values := range config.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
 
 	// Execute the request.
 	resp, err := http.DefaultClient.Do(req)
@@ -0,0 +1,170 @@
+package skynet
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// ContentTypeFunc returns the content type of a file, given its name and
+	// its first bytes. head contains fewer than 512 bytes only if the file is
+	// shorter and is empty for empty files. Returning "" falls back to the
+	// default detection.
+	ContentTypeFunc func(filename string, head []byte) string
+)
+
+const (
+	// sniffLen is the number of bytes used to sniff the content type of a
+	// file, which is the number of bytes http.DetectContentType considers.
+	sniffLen = 512
+)
+
+var (
+	// extensionContentTypes maps file extensions to content types. It is used
+	// instead of mime.TypeByExtension, which depends on the mime.types files
+	// installed on the system, so that the same files always get the same
+	// content types.
+	extensionContentTypes = map[string]string{
+		".avif":        "image/avif",
+		".bin":         "application/octet-stream",
+		".css":         "text/css; charset=utf-8",
+		".csv":         "text/csv; charset=utf-8",
+		".gif":         "image/gif",
+		".gz":          "application/gzip",
+		".htm":         "text/html; charset=utf-8",
+		".html":        "text/html; charset=utf-8",
+		".ico":         "image/x-icon",
+		".jpeg":        "image/jpeg",
+		".jpg":         "image/jpeg",
+		".js":          "text/javascript; charset=utf-8",
+		".json":        "application/json",
+		".map":         "application/json",
+		".md":          "text/markdown; charset=utf-8",
+		".mjs":         "text/javascript; charset=utf-8",
+		".mp3":         "audio/mpeg",
+		".mp4":         "video/mp4",
+		".ogg":         "audio/ogg",
+		".otf":         "font/otf",
+		".pdf":         "application/pdf",
+		".png":         "image/png",
+		".svg":         "image/svg+xml",
+		".tar":         "application/x-tar",
+		".ttf":         "font/ttf",
+		".txt":         "text/plain; charset=utf-8",
+		".wasm":        "application/wasm",
+		".wav":         "audio/wav",
+		".webm":        "video/webm",
+		".webmanifest": "application/manifest+json",
+		".webp":        "image/webp",
+		".woff":        "font/woff",
+		".woff2":       "font/woff2",
+		".xml":         "text/xml; charset=utf-8",
+		".zip":         "application/zip",
+	}
+
+	// scriptContentTypes maps the interpreters of scripts to content types.
+	// The empty interpreter is used for unknown interpreters.
+	scriptContentTypes = map[string]string{
+		"":       "text/plain; charset=utf-8",
+		"bash":   "text/x-shellscript; charset=utf-8",
+		"dash":   "text/x-shellscript; charset=utf-8",
+		"deno":   "text/javascript; charset=utf-8",
+		"ksh":    "text/x-shellscript; charset=utf-8",
+		"node":   "text/javascript; charset=utf-8",
+		"perl":   "text/x-perl; charset=utf-8",
+		"php":    "text/x-php; charset=utf-8",
+		"python": "text/x-python; charset=utf-8",
+		"ruby":   "text/x-ruby; charset=utf-8",
+		"sh":     "text/x-shellscript; charset=utf-8",
+		"zsh":    "text/x-shellscript; charset=utf-8",
+	}
+)
+
+// fileContentType returns the content type of the file with the given name
+// and data. It also returns the bytes it read from r to detect the content
+// type, which the caller has to send before the rest of r.
+func fileContentType(filename string, r io.Reader, opts UploadOptions) (string, []byte, error) {
+	var head []byte
+	var err error
+	if opts.DetectContentType != nil {
+		head, err = readHead(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if contentType := opts.DetectContentType(filename, head); contentType != "" {
+			return contentType, head, nil
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if contentType := opts.ContentTypes[ext]; contentType != "" {
+		return contentType, head, nil
+	}
+	if contentType := extensionContentTypes[ext]; contentType != "" {
+		return contentType, head, nil
+	}
+
+	if head == nil {
+		head, err = readHead(r)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if contentType := scriptContentType(head); contentType != "" {
+		return contentType, head, nil
+	}
+	// Always returns a valid content-type by returning
+	// "application/octet-stream" if no others seemed to match.
+	return http.DetectContentType(head), head, nil
+}
+
+// readHead reads the bytes used to sniff the content type from r. Fewer bytes
+// are returned if r is shorter.
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read data to detect content type")
+	}
+	return head[:n], nil
+}
+
+// scriptContentType returns the content type of a script starting with a
+// shebang line, based on its interpreter. It returns "" if the data doesn't
+// start with a shebang.
+func scriptContentType(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+	line := string(head[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	// Use the program run by env if it is used to find the interpreter.
+	fields := strings.Fields(line)
+	if len(fields) > 0 && gopath.Base(fields[0]) == "env" {
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 0 {
+		return scriptContentTypes[""]
+	}
+
+	// Strip version numbers, such as in python3 or python3.9.
+	interpreter := strings.TrimRight(gopath.Base(fields[0]), "0123456789.")
+	if contentType, ok := scriptContentTypes[interpreter]; ok {
+		return contentType
+	}
+	return scriptContentTypes[""]
+}
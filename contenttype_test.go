@@ -0,0 +1,54 @@
+package skynet
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFileContentType tests detecting the content types of files.
+func TestFileContentType(t *testing.T) {
+	custom := DefaultUploadOptions
+	custom.ContentTypes = map[string]string{".js": "application/javascript"}
+	custom.DetectContentType = func(filename string, head []byte) string {
+		if strings.HasPrefix(string(head), "custom") {
+			return "application/x-custom"
+		}
+		return ""
+	}
+
+	tests := []struct {
+		filename string
+		data     string
+		opts     UploadOptions
+		expected string
+	}{
+		{"empty", "", DefaultUploadOptions, "text/plain; charset=utf-8"},
+		{"short", "hi", DefaultUploadOptions, "text/plain; charset=utf-8"},
+		{"binary", "\x00\x01", DefaultUploadOptions, "application/octet-stream"},
+		{"page", "<!DOCTYPE html><html></html>", DefaultUploadOptions, "text/html; charset=utf-8"},
+		{"module.MJS", "export {}", DefaultUploadOptions, "text/javascript; charset=utf-8"},
+		{"app.wasm", "\x00asm", DefaultUploadOptions, "application/wasm"},
+		{"run", "#!/bin/sh\necho hi\n", DefaultUploadOptions, "text/x-shellscript; charset=utf-8"},
+		{"run", "#!/usr/bin/env -S python3.9 -u\n", DefaultUploadOptions, "text/x-python; charset=utf-8"},
+		{"run", "#! /usr/bin/env node\n", DefaultUploadOptions, "text/javascript; charset=utf-8"},
+		{"run", "#!/opt/bin/unknown", DefaultUploadOptions, "text/plain; charset=utf-8"},
+		{"app.js", "", DefaultUploadOptions, "text/javascript; charset=utf-8"},
+		{"app.js", "", custom, "application/javascript"},
+		{"app.js", "custom", custom, "application/x-custom"},
+		{"empty", "", custom, "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		r := strings.NewReader(test.data)
+		contentType, head, err := fileContentType(test.filename, r, test.opts)
+		if err != nil {
+			t.Fatal(test.filename, err)
+		}
+		if contentType != test.expected {
+			t.Errorf("%v %q: expected %v, got %v", test.filename, test.data, test.expected, contentType)
+		}
+		// The bytes that were read must be returned.
+		if rest := test.data[len(test.data)-r.Len():]; string(head)+rest != test.data {
+			t.Errorf("%v %q: expected head of data, got %q", test.filename, test.data, head)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package skynet
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"mime"
 	"net/url"
 	"os"
 	gopath "path"
@@ -20,6 +22,41 @@ type (
 		SkykeyName string
 		// SkykeyID is the ID of the skykey used to encrypt the upload.
 		SkykeyID string
+		// Skykey, if set, decrypts a skyfile that was encrypted with it by a
+		// portal locally, so the skykey is never sent to the portal. The raw
+		// sectors of the skyfile are downloaded instead. If Skykey is not set
+		// but the Keystore contains a skykey named SkykeyName, that skykey is
+		// used.
+		Skykey *Skykey
+		// EncryptionKey, if set, decrypts data that was encrypted with the
+		// EncryptionKey upload option. The download fails with
+		// ErrNotEncrypted if the data is not encrypted.
+		EncryptionKey *EncryptionKey
+
+		// Progress, if set, is called with progress updates while the response
+		// body is read.
+		Progress ProgressFunc
+	}
+
+	// DownloadResult contains the result of a download, including the
+	// relevant response headers.
+	DownloadResult struct {
+		// Body is the downloaded data. It must be closed by the caller.
+		Body io.ReadCloser
+
+		// ContentType is the content type of the data.
+		ContentType string
+		// ContentLength is the length of the data, or -1 if it is not known.
+		ContentLength int64
+		// Filename is the filename from the Content-Disposition header.
+		Filename string
+		// Skylink is the resolved skylink from the Skynet-Skylink header. For
+		// v2 skylinks this is the v1 skylink that was served.
+		Skylink string
+		// ETag is the ETag of the response.
+		ETag string
+		// PortalURL is the URL of the portal that served the request.
+		PortalURL string
 	}
 
 	// MetadataOptions contains the options used for getting metadata.
@@ -45,12 +82,35 @@ var (
 
 // Download downloads generic data.
 func (sc *SkynetClient) Download(skylink string, opts DownloadOptions) (io.ReadCloser, error) {
+	result, err := sc.DownloadWithResult(skylink, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// DownloadWithResult downloads generic data and returns it together with the
+// response headers describing it.
+func (sc *SkynetClient) DownloadWithResult(skylink string, opts DownloadOptions) (DownloadResult, error) {
 	skylink = strings.TrimPrefix(skylink, URISkynetPrefix)
+	sk, err := sc.localSkykey(opts)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	if sk != nil {
+		if opts.EncryptionKey != nil {
+			return DownloadResult{}, errors.New("EncryptionKey can't be combined with a local skykey")
+		}
+		return sc.downloadSkykeyEncrypted(skylink, *sk, opts)
+	}
 
 	values := url.Values{}
 	values.Set("skykeyname", opts.SkykeyName)
 	values.Set("skykeyid", opts.SkykeyID)
 
+	tracker := newProgressTracker(opts.Progress)
+	tracker.setPhase(ProgressPhaseWaiting)
+
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options:   opts.Options,
@@ -61,22 +121,66 @@ func (sc *SkynetClient) Download(skylink string, opts DownloadOptions) (io.ReadC
 		},
 	)
 	if err != nil {
-		return nil, errors.AddContext(err, "could not execute request")
+		return DownloadResult{}, errors.AddContext(err, "could not execute request")
+	}
+
+	tracker.setTotal(resp.ContentLength)
+	tracker.setPhase(ProgressPhaseReceiving)
+
+	result := DownloadResult{
+		Body:          newProgressReadCloser(resp.Body, tracker, ProgressPhaseDone),
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		ETag:          resp.Header.Get("ETag"),
+		PortalURL:     sc.PortalURL,
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+		_, params, err := mime.ParseMediaType(disposition)
+		if err == nil {
+			result.Filename = params["filename"]
+		}
+	}
+	if opts.EncryptionKey != nil {
+		err = decryptResult(&result, opts.EncryptionKey)
+		if err != nil {
+			return DownloadResult{}, errors.Compose(err, result.Body.Close())
+		}
 	}
+	if resolved := resp.Header.Get("Skynet-Skylink"); resolved != "" {
+		result.Skylink = URISkynetPrefix + resolved
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		result.PortalURL = fmt.Sprintf("%s://%s", resp.Request.URL.Scheme, resp.Request.URL.Host)
+	}
+	return result, nil
+}
 
-	return resp.Body, nil
+// decryptResult makes the body of the result decrypt the downloaded data with
+// the given key. The header is read right away, so that data that is not
+// encrypted or can't be decrypted with the key fails the download.
+func decryptResult(result *DownloadResult, key *EncryptionKey) error {
+	dr := NewDecryptReader(result.Body, key).(*decryptReader)
+	err := dr.readHeader()
+	if err != nil {
+		return errors.AddContext(err, "could not decrypt data")
+	}
+	if result.ContentLength >= 0 {
+		result.ContentLength = dr.cipher.plaintextSize(result.ContentLength)
+	}
+	result.Body = dr
+	return nil
 }
 
 // DownloadFile downloads a file from Skynet to path.
 func (sc *SkynetClient) DownloadFile(path, skylink string, opts DownloadOptions) (err error) {
 	path = gopath.Clean(path)
 
-	downloadData, err := sc.Download(skylink, opts)
+	result, err := sc.DownloadWithResult(skylink, opts)
 	if err != nil {
 		return errors.AddContext(err, "could not download data")
 	}
 	defer func() {
-		err = errors.Extend(err, downloadData.Close())
+		err = errors.Extend(err, result.Body.Close())
 	}()
 
 	out, err := os.Create(path)
@@ -87,8 +191,14 @@ func (sc *SkynetClient) DownloadFile(path, skylink string, opts DownloadOptions)
 		err = errors.Extend(err, out.Close())
 	}()
 
-	_, err = io.Copy(out, downloadData)
-	return errors.AddContext(err, "could not copy data to file at "+path)
+	n, err := io.Copy(out, result.Body)
+	if err != nil {
+		return errors.AddContext(err, "could not copy data to file at "+path)
+	}
+	if result.ContentLength >= 0 && n != result.ContentLength {
+		return fmt.Errorf("expected %v bytes from %v, got %v", result.ContentLength, result.PortalURL, n)
+	}
+	return nil
 }
 
 // Metadata downloads metadata from the given skylink.
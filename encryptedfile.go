@@ -0,0 +1,303 @@
+package skynet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type (
+	// EncryptedFile provides random access to a skyfile that was encrypted
+	// with an EncryptionKey. It implements io.ReadSeeker and io.ReaderAt and
+	// only downloads the encrypted chunks containing the requested data,
+	// using Range requests. If the portal ignores Range requests, the whole
+	// encrypted skyfile is downloaded once and kept in memory.
+	EncryptedFile struct {
+		sc      *SkynetClient
+		skylink string
+		opts    DownloadOptions
+
+		cipher *fileCipher
+		// size is the size of the plaintext and encryptedSize the size of the
+		// encrypted skyfile.
+		size          int64
+		encryptedSize int64
+
+		// offset is the offset of the next Read.
+		offset int64
+
+		// cachedIndex is the index of the chunk in cachedChunk, which is the
+		// last chunk that was downloaded. It lets small sequential reads
+		// download every chunk only once.
+		cachedIndex uint64
+		cachedChunk []byte
+		// encrypted is the whole encrypted skyfile if the portal ignored a
+		// Range request. Later reads are served from it.
+		encrypted []byte
+		mu        sync.Mutex
+	}
+)
+
+// OpenEncrypted opens the skyfile at the given skylink, which was encrypted
+// with opts.EncryptionKey, for random access. Only the encryption header is
+// downloaded right away.
+func (sc *SkynetClient) OpenEncrypted(skylink string, opts DownloadOptions) (*EncryptedFile, error) {
+	if opts.EncryptionKey == nil {
+		return nil, errors.New("EncryptionKey must be set to open an encrypted file")
+	}
+	ef := &EncryptedFile{
+		sc:      sc,
+		skylink: strings.TrimPrefix(skylink, URISkynetPrefix),
+		opts:    opts,
+	}
+
+	body, total, err := ef.downloadRange(0, int64(encryptionHeaderSize))
+	if err != nil {
+		return nil, errors.AddContext(err, "could not download encryption header")
+	}
+	header, err := readEncryptionHeader(body)
+	err = errors.Compose(err, body.Close())
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read encryption header")
+	}
+	ef.cipher, err = opts.EncryptionKey.newFileCipher(header)
+	if err != nil {
+		return nil, err
+	}
+
+	ef.encryptedSize = total
+	ef.size = ef.cipher.plaintextSize(total)
+	if ef.size < 0 {
+		return nil, errors.AddContext(ErrDecryptionFailed, fmt.Sprintf("invalid size of encrypted file %v", total))
+	}
+	return ef, nil
+}
+
+// Size returns the size of the decrypted file.
+func (ef *EncryptedFile) Size() int64 {
+	return ef.size
+}
+
+// Read implements io.Reader.
+func (ef *EncryptedFile) Read(p []byte) (int, error) {
+	ef.mu.Lock()
+	offset := ef.offset
+	ef.mu.Unlock()
+
+	n, err := ef.ReadAt(p, offset)
+	ef.mu.Lock()
+	ef.offset = offset + int64(n)
+	ef.mu.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (ef *EncryptedFile) Seek(offset int64, whence int) (int64, error) {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += ef.offset
+	case io.SeekEnd:
+		offset += ef.size
+	default:
+		return 0, fmt.Errorf("invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	ef.offset = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (ef *EncryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= ef.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > ef.size {
+		end = ef.size
+	}
+	if end == off {
+		return 0, nil
+	}
+
+	chunkSize := int64(ef.cipher.chunkSize)
+	first := uint64(off / chunkSize)
+	last := uint64((end - 1) / chunkSize)
+	n := 0
+	for index := first; index <= last; {
+		// Use the cached chunk if possible, otherwise download all chunks
+		// that are left at once.
+		chunks := ef.cached(index)
+		if chunks == nil {
+			var err error
+			chunks, err = ef.downloadChunks(index, last)
+			if err != nil {
+				return n, err
+			}
+		}
+		for _, chunk := range chunks {
+			chunkOffset := int64(index) * chunkSize
+			start := off + int64(n) - chunkOffset
+			n += copy(p[n:end-off], chunk[start:])
+			index++
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// cached returns the chunk with the given index if it is cached, or nil if it
+// isn't.
+func (ef *EncryptedFile) cached(index uint64) [][]byte {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	if ef.cachedChunk == nil || ef.cachedIndex != index {
+		return nil
+	}
+	return [][]byte{ef.cachedChunk}
+}
+
+// downloadChunks downloads and decrypts the chunks from first to last and
+// caches the last one.
+func (ef *EncryptedFile) downloadChunks(first, last uint64) (chunks [][]byte, err error) {
+	encryptedChunkSize := int64(ef.cipher.chunkSize + chacha20poly1305.Overhead)
+	start := int64(encryptionHeaderSize) + int64(first)*encryptedChunkSize
+	end := int64(encryptionHeaderSize) + int64(last+1)*encryptedChunkSize
+	if end > ef.encryptedSize {
+		end = ef.encryptedSize
+	}
+	lastIndex := uint64((ef.encryptedSize - int64(encryptionHeaderSize) - 1) / encryptedChunkSize)
+
+	body, _, err := ef.downloadRange(start, end)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not download encrypted chunks")
+	}
+	defer func() {
+		err = errors.Compose(err, body.Close())
+	}()
+	data := make([]byte, end-start)
+	_, err = io.ReadFull(body, data)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read encrypted chunks")
+	}
+
+	chunks = make([][]byte, 0, last-first+1)
+	for index := first; len(data) > 0; index++ {
+		size := int(encryptedChunkSize)
+		if size > len(data) {
+			size = len(data)
+		}
+		chunk, err := ef.cipher.open(nil, index, index == lastIndex, data[:size])
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		data = data[size:]
+	}
+
+	ef.mu.Lock()
+	ef.cachedIndex = last
+	ef.cachedChunk = chunks[len(chunks)-1]
+	ef.mu.Unlock()
+	return chunks, nil
+}
+
+// downloadRange downloads the bytes from start to end of the encrypted
+// skyfile and returns them together with the size of the whole skyfile. Only
+// the requested range is returned even if the portal ignores the Range
+// header, in which case the whole skyfile is kept for later calls.
+func (ef *EncryptedFile) downloadRange(start, end int64) (io.ReadCloser, int64, error) {
+	ef.mu.Lock()
+	encrypted := ef.encrypted
+	ef.mu.Unlock()
+	if encrypted != nil {
+		return encryptedRange(encrypted, start, end)
+	}
+
+	values := url.Values{}
+	values.Set("skykeyname", ef.opts.SkykeyName)
+	values.Set("skykeyid", ef.opts.SkykeyID)
+	headers := http.Header{}
+	headers.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+
+	resp, err := ef.sc.executeRequest(
+		requestOptions{
+			Options:   ef.opts.Options,
+			method:    "GET",
+			reqBody:   &bytes.Buffer{},
+			extraPath: ef.skylink,
+			query:     values,
+			headers:   headers,
+		},
+	)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "could not execute request")
+	}
+
+	// Keep the whole file if it was returned, since its size might not be
+	// known in advance and it would be downloaded again by every read.
+	if resp.StatusCode != http.StatusPartialContent {
+		encrypted, err = ioutil.ReadAll(resp.Body)
+		err = errors.Compose(err, resp.Body.Close())
+		if err != nil {
+			return nil, 0, errors.AddContext(err, "could not read skyfile")
+		}
+		ef.mu.Lock()
+		ef.encrypted = encrypted
+		ef.mu.Unlock()
+		return encryptedRange(encrypted, start, end)
+	}
+	total, err := contentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, 0, errors.Compose(err, resp.Body.Close())
+	}
+	return resp.Body, total, nil
+}
+
+// encryptedRange returns the bytes from start to end of the given encrypted
+// skyfile together with its size.
+func encryptedRange(encrypted []byte, start, end int64) (io.ReadCloser, int64, error) {
+	size := int64(len(encrypted))
+	if start > size {
+		start = size
+	}
+	if end > size {
+		end = size
+	}
+	return ioutil.NopCloser(bytes.NewReader(encrypted[start:end])), size, nil
+}
+
+// contentRangeSize returns the complete size from a Content-Range header, such
+// as "bytes 0-99/1000".
+func contentRangeSize(contentRange string) (int64, error) {
+	i := strings.LastIndexByte(contentRange, '/')
+	if !strings.HasPrefix(contentRange, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size in Content-Range %q", contentRange)
+	}
+	return size, nil
+}
@@ -3,13 +3,16 @@ package skynet
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/url"
 
 	"gitlab.com/NebulousLabs/errors"
 )
 
 type (
-	// Skykey contains information about a skykey.
+	// Skykey contains information about a skykey. Skykey is the encoded
+	// skykey, which the other fields are derived from.
 	Skykey struct {
 		Skykey string `json:"skykey"`
 		Name   string `json:"name"`
@@ -33,6 +36,17 @@ type (
 	GetSkykeysOptions struct {
 		Options
 	}
+	// DeleteSkykeyOptions contains the options used for deleteskykey.
+	DeleteSkykeyOptions struct {
+		Options
+	}
+	// ImportSkykeysOptions contains the options used for importing skykeys.
+	ImportSkykeysOptions struct {
+		// GetSkykeysOptions are used to list the skykeys that already exist.
+		GetSkykeysOptions GetSkykeysOptions
+		// AddSkykeyOptions are used to add the imported skykeys.
+		AddSkykeyOptions AddSkykeyOptions
+	}
 
 	// CreateSkykeyResponse contains the response for creating a skykey.
 	CreateSkykeyResponse Skykey
@@ -43,6 +57,27 @@ type (
 		// Skykeys is the returned list of skykeys.
 		Skykeys []Skykey `json:"skykeys"`
 	}
+
+	// ImportSkykeysResult contains the result of importing skykeys.
+	ImportSkykeysResult struct {
+		// Added contains the skykeys that were added.
+		Added []Skykey
+		// Duplicates contains the skykeys that were skipped because they
+		// already existed under the same name.
+		Duplicates []Skykey
+		// Conflicts contains the skykeys that were skipped because their name
+		// or ID is already used by a different skykey.
+		Conflicts []SkykeyConflict
+	}
+
+	// SkykeyConflict is a skykey that could not be imported because its name
+	// or ID is already used by an existing skykey.
+	SkykeyConflict struct {
+		// Skykey is the skykey that was not imported.
+		Skykey Skykey
+		// Existing is the existing skykey it conflicts with.
+		Existing Skykey
+	}
 )
 
 var (
@@ -62,6 +97,16 @@ var (
 	DefaultGetSkykeysOptions = GetSkykeysOptions{
 		Options: DefaultOptions("/skynet/skykeys"),
 	}
+	// DefaultDeleteSkykeyOptions contains the default deleteskykey options.
+	DefaultDeleteSkykeyOptions = DeleteSkykeyOptions{
+		Options: DefaultOptions("/skynet/deleteskykey"),
+	}
+	// DefaultImportSkykeysOptions contains the default options for importing
+	// skykeys.
+	DefaultImportSkykeysOptions = ImportSkykeysOptions{
+		GetSkykeysOptions: DefaultGetSkykeysOptions,
+		AddSkykeyOptions:  DefaultAddSkykeyOptions,
+	}
 )
 
 // AddSkykey stores the given base-64 encoded skykey with the skykey manager.
@@ -70,7 +115,7 @@ func (sc *SkynetClient) AddSkykey(skykey string, opts AddSkykeyOptions) error {
 	values := url.Values{}
 	values.Set("skykey", skykey)
 
-	_, err := sc.executeRequest(
+	resp, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
 			method:  "POST",
@@ -81,17 +126,25 @@ func (sc *SkynetClient) AddSkykey(skykey string, opts AddSkykeyOptions) error {
 	if err != nil {
 		return errors.AddContext(err, "could not execute request")
 	}
+	_, err = parseResponseBody(resp)
+	if err != nil {
+		return errors.AddContext(err, "could not parse response body")
+	}
 
 	return nil
 }
 
 // CreateSkykey returns a new skykey created and stored under the given name
-// with the given type. skykeyType can be either "public-id" or "private-id".
-func (sc *SkynetClient) CreateSkykey(name, skykeyType string, opts CreateSkykeyOptions) (Skykey, error) {
+// with the given type. GenerateSkykey creates skykeys without contacting a
+// portal.
+func (sc *SkynetClient) CreateSkykey(name string, skykeyType SkykeyType, opts CreateSkykeyOptions) (Skykey, error) {
+	if skykeyType != SkykeyTypePublicID && skykeyType != SkykeyTypePrivateID {
+		return Skykey{}, fmt.Errorf("invalid skykey type %v", skykeyType)
+	}
 	body := &bytes.Buffer{}
 	values := url.Values{}
 	values.Set("name", name)
-	values.Set("type", skykeyType)
+	values.Set("type", skykeyType.String())
 
 	resp, err := sc.executeRequest(
 		requestOptions{
@@ -209,3 +262,120 @@ func (sc *SkynetClient) GetSkykeys(opts GetSkykeysOptions) ([]Skykey, error) {
 
 	return apiResponse.Skykeys, nil
 }
+
+// DeleteSkykeyByName deletes the skykey with the given name.
+func (sc *SkynetClient) DeleteSkykeyByName(name string, opts DeleteSkykeyOptions) error {
+	values := url.Values{}
+	values.Set("name", name)
+	return sc.deleteSkykey(values, opts)
+}
+
+// DeleteSkykeyByID deletes the skykey with the given ID.
+func (sc *SkynetClient) DeleteSkykeyByID(id string, opts DeleteSkykeyOptions) error {
+	values := url.Values{}
+	values.Set("id", id)
+	return sc.deleteSkykey(values, opts)
+}
+
+// deleteSkykey deletes the skykey identified by the given query.
+func (sc *SkynetClient) deleteSkykey(values url.Values, opts DeleteSkykeyOptions) error {
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			method:  "POST",
+			reqBody: &bytes.Buffer{},
+			query:   values,
+		},
+	)
+	if err != nil {
+		return errors.AddContext(err, "could not execute request")
+	}
+	_, err = parseResponseBody(resp)
+	if err != nil {
+		return errors.AddContext(err, "could not parse response body")
+	}
+
+	return nil
+}
+
+// ExportSkykeys writes all skykeys to the file at path, in the same JSON
+// format GetSkykeys receives. The file is only readable by the current user.
+// It returns the exported skykeys.
+func (sc *SkynetClient) ExportSkykeys(path string, opts GetSkykeysOptions) ([]Skykey, error) {
+	skykeys, err := sc.GetSkykeys(opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not get skykeys")
+	}
+	if skykeys == nil {
+		skykeys = []Skykey{}
+	}
+	data, err := json.MarshalIndent(GetSkykeysResponse{Skykeys: skykeys}, "", "  ")
+	if err != nil {
+		return nil, errors.AddContext(err, "could not marshal skykeys")
+	}
+	err = writeFileAtomic(path, data)
+	if err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("could not write skykeys to %v", path))
+	}
+	return skykeys, nil
+}
+
+// ImportSkykeys adds the skykeys in the file at path, which was written by
+// ExportSkykeys. Nothing is imported if any of the skykeys is invalid.
+// Skykeys that already exist are skipped as duplicates, and skykeys whose
+// name or ID is used by a different skykey are skipped as conflicts. If
+// adding a skykey fails, the skykeys handled so far are returned together
+// with the error.
+func (sc *SkynetClient) ImportSkykeys(path string, opts ImportSkykeysOptions) (ImportSkykeysResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ImportSkykeysResult{}, errors.AddContext(err, fmt.Sprintf("could not read skykeys from %v", path))
+	}
+	var file GetSkykeysResponse
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return ImportSkykeysResult{}, errors.AddContext(err, "could not unmarshal skykeys")
+	}
+	for _, sk := range file.Skykeys {
+		err = sk.Validate()
+		if err != nil {
+			return ImportSkykeysResult{}, errors.AddContext(err, fmt.Sprintf("invalid skykey %q", sk.Name))
+		}
+	}
+
+	existing, err := sc.GetSkykeys(opts.GetSkykeysOptions)
+	if err != nil {
+		return ImportSkykeysResult{}, errors.AddContext(err, "could not get existing skykeys")
+	}
+	byName := make(map[string]Skykey, len(existing))
+	byID := make(map[string]Skykey, len(existing))
+	for _, sk := range existing {
+		byName[sk.Name] = sk
+		byID[sk.ID] = sk
+	}
+
+	var result ImportSkykeysResult
+	for _, sk := range file.Skykeys {
+		if other, ok := byID[sk.ID]; ok {
+			if other.Name == sk.Name {
+				result.Duplicates = append(result.Duplicates, sk)
+			} else {
+				result.Conflicts = append(result.Conflicts, SkykeyConflict{Skykey: sk, Existing: other})
+			}
+			continue
+		}
+		if other, ok := byName[sk.Name]; ok {
+			result.Conflicts = append(result.Conflicts, SkykeyConflict{Skykey: sk, Existing: other})
+			continue
+		}
+
+		err = sc.AddSkykey(sk.Skykey, opts.AddSkykeyOptions)
+		if err != nil {
+			return result, errors.AddContext(err, fmt.Sprintf("could not add skykey %q", sk.Name))
+		}
+		result.Added = append(result.Added, sk)
+		byName[sk.Name] = sk
+		byID[sk.ID] = sk
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package skynet
+
+import (
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// DefaultFanoutDataPieces is the number of data pieces portals erasure
+	// code the chunks of large files with.
+	DefaultFanoutDataPieces = 10
+	// DefaultFanoutParityPieces is the number of parity pieces portals erasure
+	// code the chunks of large files with.
+	DefaultFanoutParityPieces = 20
+
+	// fanoutBatchSegments is the number of segments of each piece that are
+	// erasure coded at once.
+	fanoutBatchSegments = 4096
+)
+
+// validateFanoutPieces checks that the given erasure coding parameters can be
+// stored in a skyfile layout.
+func validateFanoutPieces(dataPieces, parityPieces int) error {
+	if dataPieces < 1 || dataPieces > 255 {
+		return errors.New("FanoutDataPieces must be between 1 and 255")
+	}
+	if parityPieces < 0 || parityPieces > 255 {
+		return errors.New("FanoutParityPieces must be between 0 and 255")
+	}
+	if dataPieces+parityPieces > 256 {
+		return errors.New("there can't be more than 256 fanout pieces")
+	}
+	return nil
+}
+
+// computeFanout reads the data of a large skyfile from r and returns its fanout
+// and size. The data is split into chunks of dataPieces sectors, and the last
+// chunk is padded with zeros. Every chunk is erasure coded the way portals do
+// it, with each segment of the pieces being coded separately, and the merkle
+// roots of its pieces are appended to the fanout. If there is only one data
+// piece all pieces are identical, so only the root of the first piece is
+// stored.
+func computeFanout(r io.Reader, dataPieces, parityPieces int) ([]byte, uint64, error) {
+	numPieces := dataPieces + parityPieces
+	compressed := dataPieces == 1
+	var enc reedsolomon.Encoder
+	if !compressed {
+		var err error
+		enc, err = reedsolomon.New(dataPieces, parityPieces)
+		if err != nil {
+			return nil, 0, errors.AddContext(err, "could not create erasure coder")
+		}
+	}
+
+	// Every batch contains fanoutBatchSegments rows of dataPieces segments.
+	// The i-th segment of each row belongs to the i-th piece.
+	rowSize := segmentSize * dataPieces
+	batch := make([]byte, rowSize*fanoutBatchSegments)
+	shards := make([][]byte, numPieces)
+	for i := range shards {
+		shards[i] = make([]byte, segmentSize*fanoutBatchSegments)
+	}
+
+	var fanout []byte
+	var size uint64
+	for eof := false; !eof; {
+		trees := make([]merkleTree, numPieces)
+		for b := 0; b < sectorSize/segmentSize/fanoutBatchSegments; b++ {
+			n, err := io.ReadFull(r, batch)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return nil, 0, err
+			}
+			if eof && n == 0 && b == 0 {
+				// The previous chunk was the last one.
+				return fanout, size, nil
+			}
+			size += uint64(n)
+			for i := n; i < len(batch); i++ {
+				batch[i] = 0
+			}
+
+			if compressed {
+				for i := 0; i < len(batch); i += segmentSize {
+					trees[0].push(batch[i : i+segmentSize])
+				}
+				continue
+			}
+			for row := 0; row < fanoutBatchSegments; row++ {
+				for i := 0; i < dataPieces; i++ {
+					copy(shards[i][row*segmentSize:], batch[row*rowSize+i*segmentSize:row*rowSize+(i+1)*segmentSize])
+				}
+			}
+			err = enc.Encode(shards)
+			if err != nil {
+				return nil, 0, errors.AddContext(err, "could not erasure code chunk")
+			}
+			for i, shard := range shards {
+				for j := 0; j < len(shard); j += segmentSize {
+					trees[i].push(shard[j : j+segmentSize])
+				}
+			}
+		}
+
+		if compressed {
+			trees = trees[:1]
+		}
+		for _, tree := range trees {
+			root := tree.root()
+			fanout = append(fanout, root[:]...)
+		}
+	}
+	return fanout, size, nil
+}
@@ -0,0 +1,605 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type (
+	// EncryptionKey is a key for client-side encryption. Data encrypted with
+	// it can only be decrypted with the same raw key or passphrase, so
+	// portals only ever see the ciphertext.
+	EncryptionKey struct {
+		// key is the raw key. It is nil for passphrase keys.
+		key []byte
+
+		// passphrase is the passphrase keys are derived from.
+		passphrase []byte
+		// params are the KDF parameters used to derive keys for encryption.
+		params KDFParams
+		// salt is the salt used to derive the key for encryption.
+		salt [encryptionSaltSize]byte
+
+		// derived caches the keys derived from the passphrase by salt and
+		// KDF parameters. It holds at most encryptionMaxDerivedKeys keys, and
+		// the key used for encryption is never evicted.
+		derived map[kdfInput][]byte
+		mu      sync.Mutex
+	}
+
+	// KDFParams contains the parameters of Argon2id, the memory-hard key
+	// derivation function used to derive keys from passphrases.
+	KDFParams struct {
+		// Time is the number of passes over the memory.
+		Time uint32
+		// Memory is the amount of memory used in KiB.
+		Memory uint32
+		// Threads is the number of threads used.
+		Threads uint8
+	}
+
+	// kdfInput contains the inputs besides the passphrase of a key
+	// derivation.
+	kdfInput struct {
+		params KDFParams
+		salt   [encryptionSaltSize]byte
+	}
+
+	// encryptionHeader is the header of encrypted data. The header is
+	// followed by the encrypted chunks. Every chunk but the last one contains
+	// chunkSize bytes of plaintext followed by the authentication tag, so the
+	// chunk containing any offset of the plaintext can be located and
+	// decrypted on its own. The last chunk contains the rest of the plaintext
+	// and is empty only if the plaintext is.
+	encryptionHeader struct {
+		version   uint8
+		kdf       uint8
+		chunkSize uint32
+		params    KDFParams
+		salt      [encryptionSaltSize]byte
+		nonce     [encryptionNoncePrefixSize]byte
+	}
+
+	// fileCipher encrypts and decrypts the chunks of a single encrypted file.
+	fileCipher struct {
+		aead      cipher.AEAD
+		header    []byte
+		nonce     [chacha20poly1305.NonceSizeX]byte
+		chunkSize int
+	}
+
+	// encryptReader is an io.Reader that encrypts the data read from the
+	// underlying reader.
+	encryptReader struct {
+		r      io.Reader
+		cipher *fileCipher
+		index  uint64
+
+		// buf holds the next chunk plus one byte, to find out whether the
+		// chunk is the last one.
+		buf    []byte
+		buflen int
+		// sealed holds the last encrypted chunk and out the part of it that
+		// has not been read yet.
+		sealed []byte
+		out    []byte
+		done   bool
+	}
+
+	// decryptReader is an io.ReadCloser that decrypts the data read from the
+	// underlying reader.
+	decryptReader struct {
+		r      io.Reader
+		closer io.Closer
+		key    *EncryptionKey
+		cipher *fileCipher
+		index  uint64
+
+		// buf holds the next encrypted chunk plus one byte, to find out
+		// whether the chunk is the last one.
+		buf    []byte
+		buflen int
+		// plain holds the last decrypted chunk and out the part of it that
+		// has not been read yet.
+		plain []byte
+		out   []byte
+		done  bool
+		err   error
+	}
+)
+
+const (
+	// encryptionMagic identifies data encrypted by the SDK.
+	encryptionMagic = "SKYENC"
+	// encryptionVersion is the version of the encryption format.
+	encryptionVersion = 1
+	// encryptionHeaderSize is the size of the header of encrypted data.
+	encryptionHeaderSize = len(encryptionMagic) + 1 + 1 + 4 + 4 + 4 + 1 + encryptionSaltSize + encryptionNoncePrefixSize
+	// encryptionSaltSize is the size of the salt used for key derivation.
+	encryptionSaltSize = 16
+	// encryptionNoncePrefixSize is the size of the random part of the nonce of
+	// every chunk. The rest of the nonce is the index of the chunk.
+	encryptionNoncePrefixSize = chacha20poly1305.NonceSizeX - 8
+	// encryptionChunkSize is the size of the plaintext of every chunk but the
+	// last one.
+	encryptionChunkSize = 1 << 16
+	// encryptionMaxChunkSize is the largest chunk size accepted when
+	// decrypting.
+	encryptionMaxChunkSize = 1 << 24
+	// encryptionMaxDerivedKeys is the maximum number of keys derived from a
+	// passphrase that are cached by an EncryptionKey.
+	encryptionMaxDerivedKeys = 16
+
+	// kdfNone means that the key is a raw key.
+	kdfNone = 0
+	// kdfArgon2id means that the key is derived from a passphrase with
+	// Argon2id.
+	kdfArgon2id = 1
+
+	// EncryptionKeySize is the size of raw encryption keys.
+	EncryptionKeySize = chacha20poly1305.KeySize
+
+	// MaxKDFTime is the largest number of passes accepted in KDFParams.
+	MaxKDFTime = 16
+	// MaxKDFMemory is the largest amount of memory in KiB accepted in
+	// KDFParams, which is 1 GiB.
+	MaxKDFMemory = 1 << 20
+	// MaxKDFThreads is the largest number of threads accepted in KDFParams.
+	MaxKDFThreads = 64
+)
+
+var (
+	// DefaultKDFParams contains the default parameters for deriving keys from
+	// passphrases.
+	DefaultKDFParams = KDFParams{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+
+	// ErrNotEncrypted is returned when decrypting data that was not
+	// encrypted by the SDK.
+	ErrNotEncrypted = errors.New("data is not encrypted")
+	// ErrDecryptionFailed is returned when encrypted data can't be
+	// decrypted, because the key is wrong or the data was modified.
+	ErrDecryptionFailed = errors.New("could not decrypt data")
+
+	// errKeyNotInitialized is returned when encrypting or decrypting with an
+	// EncryptionKey that wasn't created by one of its constructors.
+	errKeyNotInitialized = errors.New("encryption key is not initialized")
+)
+
+// NewEncryptionKey creates an EncryptionKey from the given raw key, which must
+// be EncryptionKeySize bytes long.
+func NewEncryptionKey(key []byte) (*EncryptionKey, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %v bytes, got %v", EncryptionKeySize, len(key))
+	}
+	return &EncryptionKey{key: append([]byte(nil), key...)}, nil
+}
+
+// GenerateEncryptionKey creates an EncryptionKey from a random raw key.
+func GenerateEncryptionKey() (*EncryptionKey, error) {
+	key := make([]byte, EncryptionKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not generate key")
+	}
+	return NewEncryptionKey(key)
+}
+
+// NewPassphraseEncryptionKey creates an EncryptionKey that derives keys from
+// the given passphrase with Argon2id. Data is encrypted with a key derived
+// with the given parameters and a random salt, which are stored in the
+// header of the encrypted data. Decryption uses the parameters and salt from
+// the header.
+func NewPassphraseEncryptionKey(passphrase string, params KDFParams) (*EncryptionKey, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	err := params.validate()
+	if err != nil {
+		return nil, err
+	}
+	ek := &EncryptionKey{
+		passphrase: []byte(passphrase),
+		params:     params,
+		derived:    make(map[kdfInput][]byte),
+	}
+	_, err = rand.Read(ek.salt[:])
+	if err != nil {
+		return nil, errors.AddContext(err, "could not generate salt")
+	}
+	return ek, nil
+}
+
+// Key returns the raw key. It returns nil for passphrase keys.
+func (ek *EncryptionKey) Key() []byte {
+	return append([]byte(nil), ek.key...)
+}
+
+// EncryptedSize returns the size of the encrypted data for plaintext of the
+// given size.
+func EncryptedSize(size int64) int64 {
+	chunks := (size + encryptionChunkSize - 1) / encryptionChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(encryptionHeaderSize) + size + chunks*chacha20poly1305.Overhead
+}
+
+// NewEncryptReader returns an io.Reader that encrypts the data read from r
+// with the given key.
+func NewEncryptReader(r io.Reader, key *EncryptionKey) (io.Reader, error) {
+	if !key.initialized() {
+		return nil, errKeyNotInitialized
+	}
+	header := encryptionHeader{
+		version:   encryptionVersion,
+		kdf:       kdfNone,
+		chunkSize: encryptionChunkSize,
+	}
+	if key.key == nil {
+		header.kdf = kdfArgon2id
+		header.params = key.params
+		header.salt = key.salt
+	}
+	_, err := rand.Read(header.nonce[:])
+	if err != nil {
+		return nil, errors.AddContext(err, "could not generate nonce")
+	}
+	fc, err := key.newFileCipher(header)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		r:      r,
+		cipher: fc,
+		buf:    make([]byte, fc.chunkSize+1),
+		out:    fc.header,
+	}, nil
+}
+
+// NewDecryptReader returns an io.ReadCloser that decrypts the data read from
+// r with the given key. Closing it closes r if it is an io.Closer. Reads fail
+// with ErrNotEncrypted if the data was not encrypted by the SDK, and with
+// ErrDecryptionFailed if the key is wrong or the data was modified.
+func NewDecryptReader(r io.Reader, key *EncryptionKey) io.ReadCloser {
+	dr := &decryptReader{r: r, key: key}
+	if closer, ok := r.(io.Closer); ok {
+		dr.closer = closer
+	}
+	return dr
+}
+
+// encryptEntry returns the entry that uploads the given entry encrypted with
+// the given key.
+func encryptEntry(entry UploadEntry, key *EncryptionKey) (UploadEntry, error) {
+	size := entry.Size
+	if size == 0 {
+		size = readerSize(entry.Reader)
+	}
+	r, err := NewEncryptReader(entry.Reader, key)
+	if err != nil {
+		return UploadEntry{}, err
+	}
+	entry.Reader = r
+	entry.ContentType = "application/octet-stream"
+	entry.Size = 0
+	if size >= 0 {
+		entry.Size = EncryptedSize(size)
+	}
+	return entry, nil
+}
+
+// Read implements io.Reader.
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for len(er.out) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		err := er.sealNext()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.out)
+	er.out = er.out[n:]
+	return n, nil
+}
+
+// sealNext reads and encrypts the next chunk.
+func (er *encryptReader) sealNext() error {
+	n, err := io.ReadFull(er.r, er.buf[er.buflen:])
+	er.buflen += n
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// The chunk is the last one if there is no data after it.
+	final := er.buflen <= er.cipher.chunkSize
+	chunk := er.buf[:er.buflen]
+	if !final {
+		chunk = er.buf[:er.cipher.chunkSize]
+	}
+	er.sealed = er.cipher.seal(er.sealed[:0], er.index, final, chunk)
+	er.out = er.sealed
+	er.index++
+	if final {
+		er.done = true
+		return nil
+	}
+	er.buf[0] = er.buf[er.cipher.chunkSize]
+	er.buflen = 1
+	return nil
+}
+
+// Read implements io.Reader.
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.out) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.done {
+			return 0, io.EOF
+		}
+		dr.err = dr.openNext()
+	}
+	n := copy(p, dr.out)
+	dr.out = dr.out[n:]
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (dr *decryptReader) Close() error {
+	if dr.closer == nil {
+		return nil
+	}
+	return dr.closer.Close()
+}
+
+// readHeader reads the header and prepares the cipher if that hasn't happened
+// yet.
+func (dr *decryptReader) readHeader() error {
+	if dr.cipher != nil {
+		return nil
+	}
+	header, err := readEncryptionHeader(dr.r)
+	if err != nil {
+		return err
+	}
+	dr.cipher, err = dr.key.newFileCipher(header)
+	if err != nil {
+		return err
+	}
+	dr.buf = make([]byte, dr.cipher.chunkSize+chacha20poly1305.Overhead+1)
+	return nil
+}
+
+// openNext reads and decrypts the next chunk, reading the header first if
+// necessary.
+func (dr *decryptReader) openNext() error {
+	err := dr.readHeader()
+	if err != nil {
+		return err
+	}
+
+	n, err := io.ReadFull(dr.r, dr.buf[dr.buflen:])
+	dr.buflen += n
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// The chunk is the last one if there is no data after it.
+	size := dr.cipher.chunkSize + chacha20poly1305.Overhead
+	final := dr.buflen <= size
+	chunk := dr.buf[:dr.buflen]
+	if !final {
+		chunk = dr.buf[:size]
+	}
+	dr.plain, err = dr.cipher.open(dr.plain[:0], dr.index, final, chunk)
+	if err != nil {
+		return err
+	}
+	dr.out = dr.plain
+	dr.index++
+	if final {
+		dr.done = true
+		return nil
+	}
+	dr.buf[0] = dr.buf[size]
+	dr.buflen = 1
+	return nil
+}
+
+// newFileCipher returns the cipher for the file with the given header.
+func (ek *EncryptionKey) newFileCipher(header encryptionHeader) (*fileCipher, error) {
+	if !ek.initialized() {
+		return nil, errKeyNotInitialized
+	}
+	var key []byte
+	switch header.kdf {
+	case kdfNone:
+		if ek.key == nil {
+			return nil, errors.AddContext(ErrDecryptionFailed, "data was encrypted with a raw key, not a passphrase")
+		}
+		key = ek.key
+	case kdfArgon2id:
+		if ek.passphrase == nil {
+			return nil, errors.AddContext(ErrDecryptionFailed, "data was encrypted with a passphrase, not a raw key")
+		}
+		key = ek.deriveKey(kdfInput{params: header.params, salt: header.salt})
+	default:
+		return nil, fmt.Errorf("unknown key derivation function %v", header.kdf)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not create cipher")
+	}
+	fc := &fileCipher{
+		aead:      aead,
+		header:    header.encode(),
+		chunkSize: int(header.chunkSize),
+	}
+	copy(fc.nonce[:], header.nonce[:])
+	return fc, nil
+}
+
+// initialized returns whether the key was created with one of the
+// constructors, which set either a raw key or a passphrase.
+func (ek *EncryptionKey) initialized() bool {
+	return ek != nil && (ek.key != nil || ek.passphrase != nil)
+}
+
+// deriveKey derives the key for the given KDF inputs from the passphrase.
+func (ek *EncryptionKey) deriveKey(input kdfInput) []byte {
+	ek.mu.Lock()
+	defer ek.mu.Unlock()
+	if key, ok := ek.derived[input]; ok {
+		return key
+	}
+	key := argon2.IDKey(ek.passphrase, input.salt[:], input.params.Time, input.params.Memory, input.params.Threads, EncryptionKeySize)
+
+	// Make room by evicting any key but the one used for encryption.
+	own := kdfInput{params: ek.params, salt: ek.salt}
+	for cached := range ek.derived {
+		if len(ek.derived) < encryptionMaxDerivedKeys {
+			break
+		}
+		if cached != own {
+			delete(ek.derived, cached)
+		}
+	}
+	ek.derived[input] = key
+	return key
+}
+
+// plaintextSize returns the size of the plaintext of encrypted data of the
+// given size, including the header, or -1 if no encrypted data has that size.
+func (fc *fileCipher) plaintextSize(size int64) int64 {
+	size -= int64(encryptionHeaderSize)
+	chunkSize := int64(fc.chunkSize + chacha20poly1305.Overhead)
+	chunks, rest := size/chunkSize, size%chunkSize
+	if size < 0 || (chunks == 0 && rest == 0) || (rest > 0 && rest < chacha20poly1305.Overhead) {
+		return -1
+	}
+	if rest == 0 {
+		return chunks * int64(fc.chunkSize)
+	}
+	return chunks*int64(fc.chunkSize) + rest - chacha20poly1305.Overhead
+}
+
+// seal appends the encrypted chunk with the given index to dst. The header
+// and whether the chunk is the last one are authenticated with every chunk,
+// so that chunks can't be reordered or dropped without being detected.
+func (fc *fileCipher) seal(dst []byte, index uint64, final bool, chunk []byte) []byte {
+	nonce, ad := fc.chunkParams(index, final)
+	return fc.aead.Seal(dst, nonce, chunk, ad)
+}
+
+// open appends the decrypted chunk with the given index to dst.
+func (fc *fileCipher) open(dst []byte, index uint64, final bool, chunk []byte) ([]byte, error) {
+	nonce, ad := fc.chunkParams(index, final)
+	out, err := fc.aead.Open(dst, nonce, chunk, ad)
+	if err != nil {
+		return nil, errors.AddContext(ErrDecryptionFailed, fmt.Sprintf("chunk %v is invalid", index))
+	}
+	return out, nil
+}
+
+// chunkParams returns the nonce and the additional data of the chunk with the
+// given index.
+func (fc *fileCipher) chunkParams(index uint64, final bool) ([]byte, []byte) {
+	nonce := fc.nonce
+	binary.LittleEndian.PutUint64(nonce[encryptionNoncePrefixSize:], index)
+	ad := make([]byte, len(fc.header)+1)
+	copy(ad, fc.header)
+	if final {
+		ad[len(fc.header)] = 1
+	}
+	return nonce[:], ad
+}
+
+// encode encodes the header.
+func (h encryptionHeader) encode() []byte {
+	b := make([]byte, 0, encryptionHeaderSize)
+	b = append(b, encryptionMagic...)
+	b = append(b, h.version, h.kdf)
+	b = appendUint32(b, h.chunkSize)
+	b = appendUint32(b, h.params.Time)
+	b = appendUint32(b, h.params.Memory)
+	b = append(b, h.params.Threads)
+	b = append(b, h.salt[:]...)
+	b = append(b, h.nonce[:]...)
+	return b
+}
+
+// readEncryptionHeader reads and validates the header of encrypted data.
+func readEncryptionHeader(r io.Reader) (encryptionHeader, error) {
+	b := make([]byte, encryptionHeaderSize)
+	_, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return encryptionHeader{}, errors.AddContext(ErrNotEncrypted, "data is shorter than the encryption header")
+	}
+	if err != nil {
+		return encryptionHeader{}, errors.AddContext(err, "could not read encryption header")
+	}
+	if !bytes.HasPrefix(b, []byte(encryptionMagic)) {
+		return encryptionHeader{}, ErrNotEncrypted
+	}
+	b = b[len(encryptionMagic):]
+
+	var h encryptionHeader
+	h.version, h.kdf = b[0], b[1]
+	h.chunkSize = binary.LittleEndian.Uint32(b[2:])
+	h.params.Time = binary.LittleEndian.Uint32(b[6:])
+	h.params.Memory = binary.LittleEndian.Uint32(b[10:])
+	h.params.Threads = b[14]
+	copy(h.salt[:], b[15:])
+	copy(h.nonce[:], b[15+encryptionSaltSize:])
+
+	if h.version != encryptionVersion {
+		return encryptionHeader{}, fmt.Errorf("unsupported encryption version %v", h.version)
+	}
+	if h.chunkSize == 0 || h.chunkSize > encryptionMaxChunkSize {
+		return encryptionHeader{}, fmt.Errorf("invalid encryption chunk size %v", h.chunkSize)
+	}
+	if h.kdf == kdfArgon2id {
+		err = h.params.validate()
+		if err != nil {
+			return encryptionHeader{}, err
+		}
+	}
+	return h, nil
+}
+
+// validate checks that the KDF parameters are neither zero nor so large that
+// deriving a key would exhaust the memory or time of the client. The
+// parameters of encrypted data are read from its header, which the portal
+// controls.
+func (p KDFParams) validate() error {
+	if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+		return errors.New("KDF parameters must not be zero")
+	}
+	if p.Time > MaxKDFTime || p.Memory > MaxKDFMemory || p.Threads > MaxKDFThreads {
+		return fmt.Errorf("KDF parameters %+v exceed the maximum of %v passes, %v KiB of memory and %v threads", p, MaxKDFTime, MaxKDFMemory, MaxKDFThreads)
+	}
+	return nil
+}
+
+// appendUint32 appends the little-endian encoding of v to b.
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
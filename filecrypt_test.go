@@ -0,0 +1,239 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// testKDFParams are cheap KDF parameters to keep the tests fast.
+var testKDFParams = KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+// TestEncryptDecrypt tests encrypting and decrypting data of various sizes
+// with raw keys and passphrases.
+func TestEncryptDecrypt(t *testing.T) {
+	rawKey, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphraseKey, err := NewPassphraseEncryptionKey("correct horse battery staple", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Decrypting with a new key from the same passphrase must work, since
+	// the salt is stored in the header.
+	samePassphraseKey, err := NewPassphraseEncryptionKey("correct horse battery staple", DefaultKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameRawKey, err := NewEncryptionKey(rawKey.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize}
+	keys := [][2]*EncryptionKey{{rawKey, sameRawKey}, {passphraseKey, samePassphraseKey}}
+	for _, size := range sizes {
+		for _, key := range keys {
+			data := make([]byte, size)
+			_, _ = rand.Read(data)
+
+			r, err := NewEncryptReader(bytes.NewReader(data), key[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(encrypted)) != EncryptedSize(int64(size)) {
+				t.Fatalf("expected %v encrypted bytes, got %v", EncryptedSize(int64(size)), len(encrypted))
+			}
+			if size > 16 && bytes.Contains(encrypted, data[:16]) {
+				t.Fatal("encrypted data contains plaintext")
+			}
+
+			dr := NewDecryptReader(bytes.NewReader(encrypted), key[1])
+			decrypted, err := ioutil.ReadAll(dr)
+			if err != nil {
+				t.Fatal(size, err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatalf("size %v: decrypted data doesn't match", size)
+			}
+			if plaintextSize := dr.(*decryptReader).cipher.plaintextSize(int64(len(encrypted))); plaintextSize != int64(size) {
+				t.Fatalf("expected plaintext size %v, got %v", size, plaintextSize)
+			}
+		}
+	}
+}
+
+// TestDecryptInvalid tests that decrypting fails for data that was modified,
+// truncated or not encrypted, and for wrong keys.
+func TestDecryptInvalid(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*encryptionChunkSize+100)
+	r, err := NewEncryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt := func(encrypted []byte, key *EncryptionKey) error {
+		_, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(encrypted), key))
+		return err
+	}
+
+	otherKey, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphraseKey, err := NewPassphraseEncryptionKey("passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), encrypted...)
+	tampered[encryptionHeaderSize+encryptionChunkSize+10] ^= 1
+	tamperedHeader := append([]byte(nil), encrypted...)
+	tamperedHeader[len(encryptionMagic)+2] ^= 1
+	chunk := encryptionChunkSize + 16
+	reordered := append([]byte(nil), encrypted[:encryptionHeaderSize]...)
+	reordered = append(reordered, encrypted[encryptionHeaderSize+chunk:encryptionHeaderSize+2*chunk]...)
+	reordered = append(reordered, encrypted[encryptionHeaderSize:encryptionHeaderSize+chunk]...)
+	reordered = append(reordered, encrypted[encryptionHeaderSize+2*chunk:]...)
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		key       *EncryptionKey
+		err       error
+	}{
+		{"wrong key", encrypted, otherKey, ErrDecryptionFailed},
+		{"passphrase for raw key", encrypted, passphraseKey, ErrDecryptionFailed},
+		{"tampered", tampered, key, ErrDecryptionFailed},
+		{"tampered header", tamperedHeader, key, ErrDecryptionFailed},
+		{"reordered", reordered, key, ErrDecryptionFailed},
+		{"truncated", encrypted[:encryptionHeaderSize+2*chunk], key, ErrDecryptionFailed},
+		{"truncated header", encrypted[:encryptionHeaderSize-1], key, ErrNotEncrypted},
+		{"not encrypted", data, key, ErrNotEncrypted},
+	}
+	for _, test := range tests {
+		err := decrypt(test.encrypted, test.key)
+		if !errors.Contains(err, test.err) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+// TestKDFParamsLimits tests that KDF parameters above the limits are rejected
+// both when creating keys and in the headers of encrypted data, before any key
+// is derived.
+func TestKDFParamsLimits(t *testing.T) {
+	invalid := []KDFParams{
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: MaxKDFTime + 1, Memory: 64, Threads: 1},
+		{Time: 1, Memory: MaxKDFMemory + 1, Threads: 1},
+		{Time: 1, Memory: math.MaxUint32, Threads: 1},
+		{Time: 1, Memory: 64, Threads: MaxKDFThreads + 1},
+	}
+	for _, params := range invalid {
+		if _, err := NewPassphraseEncryptionKey("passphrase", params); err == nil {
+			t.Fatalf("expected params %+v to be rejected", params)
+		}
+	}
+	if _, err := NewPassphraseEncryptionKey("passphrase", KDFParams{Time: MaxKDFTime, Memory: 64, Threads: MaxKDFThreads}); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewPassphraseEncryptionKey("passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewEncryptReader(bytes.NewReader([]byte("data")), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptKey, err := NewPassphraseEncryptionKey("passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range invalid {
+		h := encryptionHeader{
+			version:   encryptionVersion,
+			kdf:       kdfArgon2id,
+			chunkSize: encryptionChunkSize,
+			params:    params,
+		}
+		tampered := append(h.encode(), encrypted[encryptionHeaderSize:]...)
+		_, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(tampered), decryptKey))
+		if err == nil {
+			t.Fatalf("expected header with params %+v to be rejected", params)
+		}
+	}
+	if len(decryptKey.derived) != 0 {
+		t.Fatalf("expected no derived keys, got %v", len(decryptKey.derived))
+	}
+}
+
+// TestDerivedKeyCache tests that the cache of keys derived from a passphrase
+// is bounded and keeps the key used for encryption.
+func TestDerivedKeyCache(t *testing.T) {
+	key, err := NewPassphraseEncryptionKey("passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := kdfInput{params: key.params, salt: key.salt}
+	ownKey := key.deriveKey(own)
+	for i := 0; i < 2*encryptionMaxDerivedKeys; i++ {
+		input := kdfInput{params: testKDFParams}
+		input.salt[0], input.salt[1] = byte(i), 0xFF
+		key.deriveKey(input)
+		if len(key.derived) > encryptionMaxDerivedKeys {
+			t.Fatalf("expected at most %v cached keys, got %v", encryptionMaxDerivedKeys, len(key.derived))
+		}
+	}
+	if !bytes.Equal(key.derived[own], ownKey) {
+		t.Fatal("expected the key used for encryption to stay cached")
+	}
+}
+
+// TestUninitializedKey tests that encrypting and decrypting with keys that
+// weren't created by a constructor fails with a clear error.
+func TestUninitializedKey(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewEncryptReader(bytes.NewReader([]byte("data")), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []*EncryptionKey{nil, {}} {
+		_, err := NewEncryptReader(bytes.NewReader([]byte("data")), key)
+		if !errors.Contains(err, errKeyNotInitialized) {
+			t.Fatalf("expected %v, got %v", errKeyNotInitialized, err)
+		}
+		_, err = ioutil.ReadAll(NewDecryptReader(bytes.NewReader(encrypted), key))
+		if !errors.Contains(err, errKeyNotInitialized) {
+			t.Fatalf("expected %v, got %v", errKeyNotInitialized, err)
+		}
+	}
+}
@@ -3,6 +3,9 @@ module github.com/NebulousLabs/go-skynet/v2
 go 1.13
 
 require (
+	github.com/klauspost/cpuid v1.3.1 // indirect
+	github.com/klauspost/reedsolomon v1.9.3
 	gitlab.com/NebulousLabs/errors v0.0.0-20171229012116-7ead97ef90b8
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	gopkg.in/h2non/gock.v1 v1.0.15
 )
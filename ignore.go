@@ -0,0 +1,196 @@
+package skynet
+
+import (
+	"bufio"
+	"io"
+	"os"
+	gopath "path"
+	"regexp"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// ignorePattern is a single pattern of an ignore file, with gitignore
+	// semantics.
+	ignorePattern struct {
+		re *regexp.Regexp
+		// base is the directory the pattern is relative to, "" for the root
+		// of the upload.
+		base string
+		// anchored is true if the pattern is matched against the path
+		// relative to base instead of against the name of the file.
+		anchored bool
+		dirOnly  bool
+		negate   bool
+	}
+
+	// ignoreMatcher matches paths against a list of ignore patterns. Later
+	// patterns take precedence over earlier ones.
+	ignoreMatcher struct {
+		patterns []ignorePattern
+	}
+)
+
+// newIgnorePattern parses a single line of an ignore file. Returns false if the
+// line is blank or a comment.
+func newIgnorePattern(line, base string) (ignorePattern, bool, error) {
+	// Trailing spaces are ignored unless they are escaped.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false, nil
+	}
+
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A pattern with a slash at the start or in the middle is relative to the
+	// directory of the ignore file. Other patterns match at any depth.
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false, nil
+	}
+
+	re, err := regexp.Compile(globToRegexp(line))
+	if err != nil {
+		return ignorePattern{}, false, errors.AddContext(err, "invalid pattern "+line)
+	}
+	p.re = re
+	return p, true, nil
+}
+
+// match returns whether the pattern matches the given slash-separated path
+// relative to the root of the upload.
+func (p ignorePattern) match(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(path, p.base+"/") {
+			return false
+		}
+		path = strings.TrimPrefix(path, p.base+"/")
+	}
+	if !p.anchored {
+		path = gopath.Base(path)
+	}
+	return p.re.MatchString(path)
+}
+
+// addPatterns adds glob patterns that are relative to the root of the upload.
+func (m *ignoreMatcher) addPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		p, ok, err := newIgnorePattern(pattern, "")
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return nil
+}
+
+// addIgnoreFile adds the patterns of the ignore file at path, which is located
+// in the directory base relative to the root of the upload. A missing file is
+// not an error.
+func (m *ignoreMatcher) addIgnoreFile(path, base string) (err error) {
+	file, err := os.Open(gopath.Clean(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.AddContext(err, "could not open ignore file")
+	}
+	defer func() {
+		err = errors.Extend(err, file.Close())
+	}()
+	return m.addIgnoreReader(file, base)
+}
+
+// addIgnoreReader adds the patterns read from r.
+func (m *ignoreMatcher) addIgnoreReader(r io.Reader, base string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p, ok, err := newIgnorePattern(strings.TrimSuffix(scanner.Text(), "\r"), base)
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return errors.AddContext(scanner.Err(), "could not read ignore file")
+}
+
+// match returns whether the given slash-separated path relative to the root of
+// the upload matches the patterns. The last matching pattern decides, so a
+// negated pattern can re-include a path excluded by an earlier pattern.
+func (m *ignoreMatcher) match(path string, isDir bool) bool {
+	matched := false
+	for _, p := range m.patterns {
+		if p.match(path, isDir) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// globToRegexp converts a gitignore glob to a regular expression. '*' matches
+// anything but a slash, '?' matches a single character that is not a slash,
+// '[...]' matches a character class and '**' matches across directories.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
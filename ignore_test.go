@@ -0,0 +1,58 @@
+package skynet
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestIgnoreMatcher tests matching paths against gitignore patterns.
+func TestIgnoreMatcher(t *testing.T) {
+	const ignoreFile = `
+# Comment
+node_modules/
+*.swp
+/build
+docs/*.md
+!docs/README.md
+**/tmp/**
+\#literal
+`
+	var m ignoreMatcher
+	err := m.addIgnoreReader(strings.NewReader(ignoreFile), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.addIgnoreReader(strings.NewReader("*.log\n!keep.log\n"), "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"node_modules", true, true},
+		{"a/node_modules", true, true},
+		{"node_modules", false, false},
+		{".index.html.swp", false, true},
+		{"a/b/file.swp", false, true},
+		{"build", true, true},
+		{"a/build", true, false},
+		{"docs/guide.md", false, true},
+		{"docs/README.md", false, false},
+		{"docs/a/guide.md", false, false},
+		{"a/tmp/b/c.txt", false, true},
+		{"#literal", false, true},
+		{"index.html", false, false},
+		{"sub/debug.log", false, true},
+		{"sub/a/debug.log", false, true},
+		{"sub/keep.log", false, false},
+		{"debug.log", false, false},
+	}
+	for _, test := range tests {
+		if ignored := m.match(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("expected ignored to be %v for %v, got %v", test.ignored, test.path, ignored)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package skynet
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type (
+	// PublicKey is an Ed25519 public key, which identifies the owner of
+	// registry entries. It is encoded as "ed25519:" followed by the hex
+	// encoded key.
+	PublicKey ed25519.PublicKey
+
+	// KeyPair is an Ed25519 key pair for signing registry entries.
+	KeyPair struct {
+		PublicKey  PublicKey
+		PrivateKey ed25519.PrivateKey
+	}
+)
+
+const (
+	// publicKeyAlgorithm is the algorithm prefix of encoded public keys.
+	publicKeyAlgorithm = "ed25519"
+
+	// seedKDFIterations is the number of PBKDF2 iterations used by skynet-js
+	// to derive key pairs from seeds.
+	seedKDFIterations = 1000
+)
+
+// GenerateKeyPair generates a random key pair.
+func GenerateKeyPair() (KeyPair, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, errors.AddContext(err, "could not generate key pair")
+	}
+	return KeyPair{PublicKey: PublicKey(publicKey), PrivateKey: privateKey}, nil
+}
+
+// KeyPairFromSeed derives a key pair from the given seed. The key pair is the
+// same as the one derived by genKeyPairFromSeed of skynet-js, so apps using
+// either SDK can share identities. The seed is hashed with PBKDF2-SHA256, so
+// it should be long and random.
+func KeyPairFromSeed(seed string) KeyPair {
+	key := pbkdf2.Key([]byte(seed), nil, seedKDFIterations, ed25519.SeedSize, sha256.New)
+	privateKey := ed25519.NewKeyFromSeed(key)
+	return KeyPair{
+		PublicKey:  PublicKey(privateKey.Public().(ed25519.PublicKey)),
+		PrivateKey: privateKey,
+	}
+}
+
+// ParsePublicKey decodes a public key such as "ed25519:4f2a...". Keys without
+// the algorithm prefix, as used by skynet-js, are accepted as well.
+func ParsePublicKey(s string) (PublicKey, error) {
+	encoded := strings.TrimPrefix(s, publicKeyAlgorithm+":")
+	if i := strings.IndexByte(encoded, ':'); i >= 0 {
+		return nil, fmt.Errorf("unsupported public key algorithm %q", encoded[:i])
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not decode public key")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %v bytes, got %v", ed25519.PublicKeySize, len(key))
+	}
+	return PublicKey(key), nil
+}
+
+// String returns the public key in the form "ed25519:<hex>".
+func (pk PublicKey) String() string {
+	return publicKeyAlgorithm + ":" + hex.EncodeToString(pk)
+}
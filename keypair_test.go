@@ -0,0 +1,76 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// TestKeyPairFromSeed tests deriving a key pair from a seed against the key
+// pair derived by skynet-js.
+func TestKeyPairFromSeed(t *testing.T) {
+	const expectedPublicKey = "658b900df55e983ce85f3f9fb2a088d568ab514e7bbda51cfbfb16ea945378d9"
+	const expectedPrivateKey = "7caffac49ac914a541b28723f11776d36ce81e7b9b0c96ccacd1302db429c79c658b900df55e983ce85f3f9fb2a088d568ab514e7bbda51cfbfb16ea945378d9"
+
+	kp := KeyPairFromSeed("insecure test seed")
+	if publicKey := hex.EncodeToString(kp.PublicKey); publicKey != expectedPublicKey {
+		t.Fatalf("expected public key %v, got %v", expectedPublicKey, publicKey)
+	}
+	if privateKey := hex.EncodeToString(kp.PrivateKey); privateKey != expectedPrivateKey {
+		t.Fatalf("expected private key %v, got %v", expectedPrivateKey, privateKey)
+	}
+	if s := kp.PublicKey.String(); s != "ed25519:"+expectedPublicKey {
+		t.Fatalf("expected encoded public key ed25519:%v, got %v", expectedPublicKey, s)
+	}
+	if kp2 := KeyPairFromSeed("insecure test seed 2"); bytes.Equal(kp.PublicKey, kp2.PublicKey) {
+		t.Fatal("expected different seeds to derive different keys")
+	}
+}
+
+// TestGenerateKeyPair tests generating random key pairs.
+func TestGenerateKeyPair(t *testing.T) {
+	kp, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp2, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(kp.PrivateKey, kp2.PrivateKey) {
+		t.Fatal("expected different key pairs")
+	}
+	sig := ed25519.Sign(kp.PrivateKey, []byte("data"))
+	if !ed25519.Verify(ed25519.PublicKey(kp.PublicKey), []byte("data"), sig) {
+		t.Fatal("public key doesn't match private key")
+	}
+}
+
+// TestParsePublicKey tests parsing encoded public keys.
+func TestParsePublicKey(t *testing.T) {
+	kp := KeyPairFromSeed("insecure test seed")
+	for _, s := range []string{kp.PublicKey.String(), hex.EncodeToString(kp.PublicKey)} {
+		pk, err := ParsePublicKey(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pk, kp.PublicKey) {
+			t.Fatalf("expected %v, got %v", kp.PublicKey, pk)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"ed25519:",
+		"ed25519:abcd",
+		"ed25519:" + hex.EncodeToString(kp.PublicKey) + "00",
+		"ed25519:" + hex.EncodeToString(kp.PublicKey)[1:] + "x",
+		"secp256k1:" + hex.EncodeToString(kp.PublicKey),
+	}
+	for _, s := range invalid {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Fatalf("expected %q to be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// Keystore is a local file that stores skykeys, portal API keys and
+	// registry seeds. The file is encrypted with a key derived from a
+	// passphrase, the same way files are encrypted with a passphrase
+	// EncryptionKey. Every operation reads the file, and every change
+	// atomically replaces it.
+	Keystore struct {
+		path string
+		key  *EncryptionKey
+		mu   sync.Mutex
+	}
+
+	// keystoreData is the decrypted content of a keystore file.
+	keystoreData struct {
+		// Skykeys contains the skykeys by name.
+		Skykeys map[string]Skykey `json:"skykeys"`
+		// APIKeys contains the API keys by portal URL.
+		APIKeys map[string]string `json:"apikeys"`
+		// Seeds contains the registry seeds by name.
+		Seeds map[string]string `json:"seeds"`
+	}
+)
+
+var (
+	// ErrKeyNotFound is returned when a keystore doesn't contain the
+	// requested key.
+	ErrKeyNotFound = errors.New("key not found in keystore")
+	// ErrKeyExists is returned when adding a key to a keystore under a name
+	// that is already used.
+	ErrKeyExists = errors.New("key already exists in keystore")
+)
+
+// OpenKeystore opens the keystore at path, which is encrypted with a key
+// derived from passphrase. The file is created on the first write, using the
+// given parameters for key derivation. If the file exists, the passphrase is
+// checked by decrypting it, which fails with ErrDecryptionFailed for a wrong
+// passphrase.
+func OpenKeystore(path, passphrase string, params KDFParams) (*Keystore, error) {
+	key, err := NewPassphraseEncryptionKey(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	ks := &Keystore{path: path, key: key}
+	_, err = ks.load()
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// AddSkykey adds the given skykey under its name. The skykey must be valid,
+// and its name must not be empty or used by another skykey.
+func (ks *Keystore) AddSkykey(sk Skykey) error {
+	if sk.Name == "" {
+		return errors.New("skykey name must be set")
+	}
+	err := sk.Validate()
+	if err != nil {
+		return err
+	}
+	return ks.update(func(data *keystoreData) error {
+		if _, exists := data.Skykeys[sk.Name]; exists {
+			return errors.AddContext(ErrKeyExists, fmt.Sprintf("skykey %q", sk.Name))
+		}
+		data.Skykeys[sk.Name] = sk
+		return nil
+	})
+}
+
+// Skykey returns the skykey with the given name.
+func (ks *Keystore) Skykey(name string) (Skykey, error) {
+	data, err := ks.read()
+	if err != nil {
+		return Skykey{}, err
+	}
+	sk, exists := data.Skykeys[name]
+	if !exists {
+		return Skykey{}, errors.AddContext(ErrKeyNotFound, fmt.Sprintf("skykey %q", name))
+	}
+	return sk, nil
+}
+
+// Skykeys returns all skykeys, sorted by name.
+func (ks *Keystore) Skykeys() ([]Skykey, error) {
+	data, err := ks.read()
+	if err != nil {
+		return nil, err
+	}
+	skykeys := make([]Skykey, 0, len(data.Skykeys))
+	for _, sk := range data.Skykeys {
+		skykeys = append(skykeys, sk)
+	}
+	sort.Slice(skykeys, func(i, j int) bool {
+		return skykeys[i].Name < skykeys[j].Name
+	})
+	return skykeys, nil
+}
+
+// RemoveSkykey removes the skykey with the given name.
+func (ks *Keystore) RemoveSkykey(name string) error {
+	return ks.update(func(data *keystoreData) error {
+		if _, exists := data.Skykeys[name]; !exists {
+			return errors.AddContext(ErrKeyNotFound, fmt.Sprintf("skykey %q", name))
+		}
+		delete(data.Skykeys, name)
+		return nil
+	})
+}
+
+// SetAPIKey sets the API key used for the portal at portalURL, replacing any
+// previous API key.
+func (ks *Keystore) SetAPIKey(portalURL, apiKey string) error {
+	if apiKey == "" {
+		return errors.New("API key must be set")
+	}
+	return ks.update(func(data *keystoreData) error {
+		data.APIKeys[normalizePortalURL(portalURL)] = apiKey
+		return nil
+	})
+}
+
+// APIKey returns the API key of the portal at portalURL.
+func (ks *Keystore) APIKey(portalURL string) (string, error) {
+	data, err := ks.read()
+	if err != nil {
+		return "", err
+	}
+	apiKey, exists := data.APIKeys[normalizePortalURL(portalURL)]
+	if !exists {
+		return "", errors.AddContext(ErrKeyNotFound, fmt.Sprintf("API key for %v", portalURL))
+	}
+	return apiKey, nil
+}
+
+// APIKeys returns all API keys by portal URL.
+func (ks *Keystore) APIKeys() (map[string]string, error) {
+	data, err := ks.read()
+	if err != nil {
+		return nil, err
+	}
+	return data.APIKeys, nil
+}
+
+// RemoveAPIKey removes the API key of the portal at portalURL.
+func (ks *Keystore) RemoveAPIKey(portalURL string) error {
+	return ks.update(func(data *keystoreData) error {
+		portalURL = normalizePortalURL(portalURL)
+		if _, exists := data.APIKeys[portalURL]; !exists {
+			return errors.AddContext(ErrKeyNotFound, fmt.Sprintf("API key for %v", portalURL))
+		}
+		delete(data.APIKeys, portalURL)
+		return nil
+	})
+}
+
+// AddSeed adds the given registry seed under name, which must not be used by
+// another seed.
+func (ks *Keystore) AddSeed(name, seed string) error {
+	if name == "" || seed == "" {
+		return errors.New("seed name and seed must be set")
+	}
+	return ks.update(func(data *keystoreData) error {
+		if _, exists := data.Seeds[name]; exists {
+			return errors.AddContext(ErrKeyExists, fmt.Sprintf("seed %q", name))
+		}
+		data.Seeds[name] = seed
+		return nil
+	})
+}
+
+// Seed returns the registry seed with the given name.
+func (ks *Keystore) Seed(name string) (string, error) {
+	data, err := ks.read()
+	if err != nil {
+		return "", err
+	}
+	seed, exists := data.Seeds[name]
+	if !exists {
+		return "", errors.AddContext(ErrKeyNotFound, fmt.Sprintf("seed %q", name))
+	}
+	return seed, nil
+}
+
+// Seeds returns all registry seeds by name.
+func (ks *Keystore) Seeds() (map[string]string, error) {
+	data, err := ks.read()
+	if err != nil {
+		return nil, err
+	}
+	return data.Seeds, nil
+}
+
+// RemoveSeed removes the registry seed with the given name.
+func (ks *Keystore) RemoveSeed(name string) error {
+	return ks.update(func(data *keystoreData) error {
+		if _, exists := data.Seeds[name]; !exists {
+			return errors.AddContext(ErrKeyNotFound, fmt.Sprintf("seed %q", name))
+		}
+		delete(data.Seeds, name)
+		return nil
+	})
+}
+
+// read returns the content of the keystore.
+func (ks *Keystore) read() (keystoreData, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.load()
+}
+
+// update applies fn to the content of the keystore and writes the result.
+// Nothing is written if fn fails.
+func (ks *Keystore) update(fn func(*keystoreData) error) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	data, err := ks.load()
+	if err != nil {
+		return err
+	}
+	err = fn(&data)
+	if err != nil {
+		return err
+	}
+	return ks.save(data)
+}
+
+// load reads and decrypts the keystore from disk.
+func (ks *Keystore) load() (keystoreData, error) {
+	data := keystoreData{
+		Skykeys: make(map[string]Skykey),
+		APIKeys: make(map[string]string),
+		Seeds:   make(map[string]string),
+	}
+	encrypted, err := ioutil.ReadFile(ks.path)
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return keystoreData{}, errors.AddContext(err, "could not read keystore")
+	}
+	r := NewDecryptReader(bytes.NewReader(encrypted), ks.key)
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return keystoreData{}, errors.AddContext(err, "could not decrypt keystore")
+	}
+	err = json.Unmarshal(plaintext, &data)
+	if err != nil {
+		return keystoreData{}, errors.AddContext(err, "could not unmarshal keystore")
+	}
+	return data, nil
+}
+
+// save encrypts and atomically writes the keystore to disk.
+func (ks *Keystore) save(data keystoreData) error {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal keystore")
+	}
+	r, err := NewEncryptReader(bytes.NewReader(plaintext), ks.key)
+	if err != nil {
+		return err
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.AddContext(err, "could not encrypt keystore")
+	}
+	return errors.AddContext(writeFileAtomic(ks.path, encrypted), "could not write keystore")
+}
+
+// normalizePortalURL normalizes a portal URL for looking up its API key.
+func normalizePortalURL(portalURL string) string {
+	return strings.TrimRight(portalURL, "/")
+}
@@ -0,0 +1,137 @@
+package skynet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestKeystore tests adding, getting, listing and removing keys and reopening
+// the keystore.
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keystore")
+
+	ks, err := OpenKeystore(path, "passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("keystore should only be created on the first write")
+	}
+
+	// Skykeys.
+	sk1, err := GenerateSkykey("key1", SkykeyTypePrivateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk2, err := GenerateSkykey("key2", SkykeyTypePublicID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sk := range []Skykey{sk2, sk1} {
+		if err := ks.AddSkykey(sk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ks.AddSkykey(sk1); !errors.Contains(err, ErrKeyExists) {
+		t.Fatalf("expected %v, got %v", ErrKeyExists, err)
+	}
+	invalid := sk1
+	invalid.Name = "invalid"
+	if err := ks.AddSkykey(invalid); !errors.Contains(err, ErrInvalidSkykey) {
+		t.Fatalf("expected %v, got %v", ErrInvalidSkykey, err)
+	}
+	sk, err := ks.Skykey("key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sk != sk1 {
+		t.Fatalf("expected %v, got %v", sk1, sk)
+	}
+
+	// API keys and seeds.
+	if err := ks.SetAPIKey("https://siasky.net/", "apikey1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetAPIKey("https://siasky.net", "apikey2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddSeed("seed", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddSeed("seed", "other seed"); !errors.Contains(err, ErrKeyExists) {
+		t.Fatalf("expected %v, got %v", ErrKeyExists, err)
+	}
+
+	// Reopen the keystore and check its content.
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{sk1.Skykey, "apikey2", "correct horse"} {
+		if bytes.Contains(contents, []byte(secret)) {
+			t.Fatalf("keystore contains %q in plaintext", secret)
+		}
+	}
+	ks, err = OpenKeystore(path, "passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skykeys, err := ks.Skykeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skykeys, []Skykey{sk1, sk2}) {
+		t.Fatalf("unexpected skykeys %v", skykeys)
+	}
+	apiKey, err := ks.APIKey("https://siasky.net/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey != "apikey2" {
+		t.Fatalf("expected apikey2, got %v", apiKey)
+	}
+	seed, err := ks.Seed("seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seed != "correct horse battery staple" {
+		t.Fatalf("unexpected seed %v", seed)
+	}
+
+	// Remove the keys.
+	if err := ks.RemoveSkykey("key1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.RemoveAPIKey("https://siasky.net"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.RemoveSeed("seed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Skykey("key1"); !errors.Contains(err, ErrKeyNotFound) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if _, err := ks.APIKey("https://siasky.net"); !errors.Contains(err, ErrKeyNotFound) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if err := ks.RemoveSeed("seed"); !errors.Contains(err, ErrKeyNotFound) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotFound, err)
+	}
+
+	// A wrong passphrase must be detected when opening the keystore.
+	_, err = OpenKeystore(path, "wrong passphrase", testKDFParams)
+	if !errors.Contains(err, ErrDecryptionFailed) {
+		t.Fatalf("expected %v, got %v", ErrDecryptionFailed, err)
+	}
+}
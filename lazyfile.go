@@ -0,0 +1,159 @@
+package skynet
+
+import (
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// lazyFile is an io.Reader over a file found while walking a directory.
+	// The file is only opened once it is read and is closed as soon as it has
+	// been read in full, so that directory uploads only keep one file open at
+	// a time no matter how many files they contain.
+	lazyFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+
+		file *os.File
+		read int64
+		done bool
+	}
+)
+
+// newLazyFile creates a lazyFile for the given file found while walking a
+// directory.
+func newLazyFile(file dirFile) *lazyFile {
+	return &lazyFile{
+		path:    file.path,
+		size:    file.size,
+		modTime: file.modTime,
+	}
+}
+
+// Read implements io.Reader.
+func (lf *lazyFile) Read(p []byte) (int, error) {
+	if lf.done {
+		return 0, io.EOF
+	}
+	if lf.file == nil {
+		err := lf.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.file.Read(p)
+	lf.read += int64(n)
+	if lf.read > lf.size {
+		err = lf.changedErr(fmt.Sprintf("expected %v bytes, got more", lf.size))
+	} else if err == io.EOF && lf.read != lf.size {
+		err = lf.changedErr(fmt.Sprintf("expected %v bytes, got %v", lf.size, lf.read))
+	}
+	if err != nil {
+		closeErr := lf.file.Close()
+		lf.file = nil
+		lf.done = true
+		if err != io.EOF {
+			return n, errors.Compose(err, closeErr)
+		}
+		if closeErr != nil {
+			return n, errors.AddContext(closeErr, fmt.Sprintf("could not close file %v", lf.path))
+		}
+	}
+	return n, err
+}
+
+// Close closes the file if it is open. The next Read opens it again and reads
+// it from the start.
+func (lf *lazyFile) Close() error {
+	var err error
+	if lf.file != nil {
+		err = lf.file.Close()
+	}
+	lf.file = nil
+	lf.read = 0
+	lf.done = false
+	return err
+}
+
+// Seek implements io.Seeker, so that the file can be read in advance and
+// rewound. The file stays closed if it isn't open yet.
+func (lf *lazyFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += lf.read
+	case io.SeekEnd:
+		offset += lf.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 || offset > lf.size {
+		return 0, fmt.Errorf("offset %v is outside of file %v", offset, lf.path)
+	}
+	if lf.file != nil {
+		_, err := lf.file.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+	}
+	lf.read = offset
+	lf.done = false
+	return offset, nil
+}
+
+// Len returns the number of bytes left in the file, based on the size of the
+// file when the directory was walked.
+func (lf *lazyFile) Len() int {
+	if lf.done {
+		return 0
+	}
+	return int(lf.size - lf.read)
+}
+
+// open opens the file and verifies that it hasn't changed since the directory
+// was walked.
+func (lf *lazyFile) open() error {
+	file, err := os.Open(gopath.Clean(lf.path)) // Clean to prevent lint error.
+	if os.IsNotExist(err) {
+		return lf.changedErr("file was removed")
+	}
+	if err != nil {
+		return errors.AddContext(err, fmt.Sprintf("could not open file %v", lf.path))
+	}
+	info, err := file.Stat()
+	if err == nil && (info.Size() != lf.size || !info.ModTime().Equal(lf.modTime)) {
+		err = lf.changedErr("file was modified")
+	}
+	if err == nil && lf.read > 0 {
+		_, err = file.Seek(lf.read, io.SeekStart)
+	}
+	if err != nil {
+		return errors.Compose(err, file.Close())
+	}
+	lf.file = file
+	return nil
+}
+
+// changedErr returns an ErrFileChanged for the file with the given reason.
+func (lf *lazyFile) changedErr(reason string) error {
+	return errors.AddContext(ErrFileChanged, fmt.Sprintf("%v: %v", lf.path, reason))
+}
+
+// closeLazyFiles closes the lazily opened files among the given entries,
+// which may still be open if an upload was aborted.
+func closeLazyFiles(entries []UploadEntry) error {
+	var err error
+	for _, entry := range entries {
+		if lf, ok := entry.Reader.(*lazyFile); ok {
+			err = errors.Compose(err, lf.Close())
+		}
+	}
+	return err
+}
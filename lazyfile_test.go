@@ -0,0 +1,107 @@
+package skynet
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestLazyFile tests that lazy files are only open while they are read and
+// that changes after the walk are detected.
+func TestLazyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	newFile := func(data string) *lazyFile {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newLazyFile(dirFile{path: path, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	// The file is not opened until it is read and is closed after reading it
+	// in full.
+	lf := newFile("data")
+	if lf.file != nil || lf.Len() != 4 {
+		t.Fatal("file should not be open yet")
+	}
+	data, err := ioutil.ReadAll(lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" || lf.file != nil || lf.Len() != 0 {
+		t.Fatalf("unexpected state after reading %q: %+v", data, lf)
+	}
+
+	// Closing the file rewinds it.
+	if err := lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(lf)
+	if err != nil || string(data) != "data" {
+		t.Fatalf("expected %q, got %q, %v", "data", data, err)
+	}
+
+	// Seeking works whether the file is open or not.
+	if _, err := lf.Seek(1, io.SeekStart); err != nil || lf.file != nil {
+		t.Fatalf("expected closed file after seeking, got %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(lf, buf); err != nil || string(buf) != "at" {
+		t.Fatalf("expected %q, got %q, %v", "at", buf, err)
+	}
+	if offset, err := lf.Seek(-2, io.SeekCurrent); err != nil || offset != 1 {
+		t.Fatalf("expected offset 1, got %v, %v", offset, err)
+	}
+	data, err = ioutil.ReadAll(lf)
+	if err != nil || string(data) != "ata" {
+		t.Fatalf("expected %q, got %q, %v", "ata", data, err)
+	}
+	if _, err := lf.Seek(5, io.SeekStart); err == nil {
+		t.Fatal("expected seeking past the end to fail")
+	}
+
+	// Modifying the file after the walk is detected.
+	lf = newFile("data")
+	if err := ioutil.WriteFile(path, []byte("longer data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(lf); !errors.Contains(err, ErrFileChanged) {
+		t.Fatalf("expected %v, got %v", ErrFileChanged, err)
+	}
+	if lf.file != nil {
+		t.Fatal("file should be closed after an error")
+	}
+
+	// So is modifying it without changing the size.
+	lf = newFile("data")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(lf); !errors.Contains(err, ErrFileChanged) {
+		t.Fatalf("expected %v, got %v", ErrFileChanged, err)
+	}
+
+	// And removing it.
+	lf = newFile("data")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(lf); !errors.Contains(err, ErrFileChanged) {
+		t.Fatalf("expected %v, got %v", ErrFileChanged, err)
+	}
+}
@@ -0,0 +1,60 @@
+package skynet
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// merkleTree computes the Sia merkle root of data pushed to it one segment
+	// at a time, using memory logarithmic in the size of the data. Leaves are
+	// hashed with a 0 prefix and nodes with a 1 prefix.
+	merkleTree struct {
+		// stack contains the roots of complete subtrees, with the root of the
+		// subtree of height i at index i if it exists.
+		stack [][32]byte
+		used  []bool
+		buf   [1 + 2*blake2b.Size256]byte
+	}
+)
+
+// sectorMerkleRoot returns the merkle root of the given data, whose length
+// must be a power of two multiple of segmentSize.
+func sectorMerkleRoot(data []byte) [32]byte {
+	var tree merkleTree
+	for i := 0; i < len(data); i += segmentSize {
+		tree.push(data[i : i+segmentSize])
+	}
+	return tree.root()
+}
+
+// push adds a segment of segmentSize bytes to the tree.
+func (t *merkleTree) push(segment []byte) {
+	t.buf[0] = 0
+	copy(t.buf[1:], segment)
+	hash := blake2b.Sum256(t.buf[:1+segmentSize])
+
+	// Join the new leaf with the complete subtrees of the same height.
+	height := 0
+	for ; height < len(t.used) && t.used[height]; height++ {
+		t.buf[0] = 1
+		copy(t.buf[1:], t.stack[height][:])
+		copy(t.buf[1+blake2b.Size256:], hash[:])
+		hash = blake2b.Sum256(t.buf[:])
+		t.used[height] = false
+	}
+	if height == len(t.used) {
+		t.stack = append(t.stack, [32]byte{})
+		t.used = append(t.used, false)
+	}
+	t.stack[height] = hash
+	t.used[height] = true
+}
+
+// root returns the merkle root of the segments pushed so far. The number of
+// segments must be a power of two.
+func (t *merkleTree) root() [32]byte {
+	if len(t.stack) == 0 {
+		return [32]byte{}
+	}
+	return t.stack[len(t.stack)-1]
+}
@@ -0,0 +1,414 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// uploadPart is a single file of a multipart upload.
+	uploadPart struct {
+		filename string
+		header   textproto.MIMEHeader
+		reader   io.Reader
+		// size is the size of the data in reader, or -1 if it is not known.
+		size int64
+	}
+
+	// multipartBody is a multipart request body that is written on the fly
+	// by a background goroutine as it is being read.
+	multipartBody struct {
+		*io.PipeReader
+
+		// contentLength is the exact length of the body, or -1 if the size of
+		// any of the parts is not known.
+		contentLength int64
+		// contentType is the Content-Type of the body, including the boundary.
+		contentType string
+
+		errChan chan error
+	}
+
+	// boundaryWriter is an io.Writer that looks for a multipart boundary in
+	// the data written to it before passing the data on to w, if set.
+	boundaryWriter struct {
+		w        io.Writer
+		boundary []byte
+		// tail contains the end of the data written so far, so that
+		// boundaries spanning multiple writes are found.
+		tail  []byte
+		found bool
+	}
+
+	// countingWriter is an io.Writer that counts and discards the bytes
+	// written to it.
+	countingWriter struct {
+		n int64
+	}
+
+	// fileProgressReader is an io.Reader that reports the bytes read from it
+	// to a progressTracker as progress of the current file.
+	fileProgressReader struct {
+		io.Reader
+		tracker *progressTracker
+	}
+)
+
+var (
+	// errBoundaryInContents is returned when the contents of a file contain
+	// the boundary of the multipart body they are sent in.
+	errBoundaryInContents = errors.New("file contains the multipart boundary")
+)
+
+// newUploadPart creates a new part for the given entry. If it is not set, the
+// Content-Type is inferred before any data is sent, so that all part headers
+// are known in advance.
+func newUploadPart(fieldname string, entry UploadEntry, opts UploadOptions) (uploadPart, error) {
+	size := entry.Size
+	if !entry.SizeKnown {
+		size = readerSize(entry.Reader)
+	} else if size < 0 {
+		return uploadPart{}, fmt.Errorf("invalid size %v of file %v", size, entry.Filename)
+	}
+
+	reader := entry.Reader
+	contentType := entry.ContentType
+	if contentType == "" {
+		// We may need to do a read to determine the Content-Type. Rewind the
+		// reader afterwards if possible, so that its contents can be read in
+		// advance to derive the boundary. Otherwise, send the bytes that were
+		// read before the rest of the data.
+		offset, seekable := readerOffset(entry.Reader)
+		var head []byte
+		var err error
+		contentType, head, err = fileContentType(entry.Filename, entry.Reader, opts)
+		if err != nil {
+			return uploadPart{}, err
+		}
+		if seekable && len(head) > 0 {
+			_, err = entry.Reader.(io.Seeker).Seek(offset, io.SeekStart)
+			if err != nil {
+				return uploadPart{}, errors.AddContext(err, "could not rewind file")
+			}
+		} else if len(head) > 0 {
+			reader = io.MultiReader(bytes.NewReader(head), entry.Reader)
+		}
+	}
+
+	header := formFileHeader(fieldname, entry.Filename, contentType)
+	if entry.Mode != 0 {
+		header.Set("Mode", fmt.Sprintf("%o", unixMode(entry.Mode)))
+	}
+	if entry.SizeKnown {
+		header.Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	}
+
+	return uploadPart{
+		filename: entry.Filename,
+		header:   header,
+		reader:   reader,
+		size:     size,
+	}, nil
+}
+
+// newMultipartBody creates a new multipart body for the given parts. The body
+// is streamed, so that only a small, constant amount of memory is used no
+// matter the size of the parts. The body must be closed after the request
+// completes.
+func newMultipartBody(parts []uploadPart, tracker *progressTracker) (*multipartBody, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	boundary, err := multipartBoundary(parts)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not derive boundary")
+	}
+	err = writer.SetBoundary(boundary)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not set boundary")
+	}
+
+	contentLength, err := multipartLength(parts, writer.Boundary())
+	if err != nil {
+		return nil, errors.AddContext(err, "could not compute content length")
+	}
+
+	body := &multipartBody{
+		PipeReader:    pr,
+		contentLength: contentLength,
+		contentType:   writer.FormDataContentType(),
+		errChan:       make(chan error, 1),
+	}
+	go func() {
+		err := writeMultipart(writer, parts, tracker)
+		body.errChan <- err
+		pw.CloseWithError(err)
+	}()
+	return body, nil
+}
+
+// writeErr closes the body and returns the error encountered while writing
+// it, if any. An error caused by the body being closed before it was read in
+// full is ignored.
+func (mb *multipartBody) writeErr() error {
+	err := mb.Close()
+	writeErr := <-mb.errChan
+	if errors.Contains(writeErr, io.ErrClosedPipe) {
+		writeErr = nil
+	}
+	return errors.Compose(err, writeErr)
+}
+
+// multipartBoundary returns a boundary derived from the headers, sizes and
+// contents of the given parts, so that the same parts are always sent with the
+// same boundary. A boundary derived from the contents can't be planted in
+// them, but the contents are checked for the boundary anyway.
+func multipartBoundary(parts []uploadPart) (string, error) {
+	h := sha256.New()
+	for _, part := range parts {
+		keys := make([]string, 0, len(part.header))
+		for key := range part.header {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(h, "%s: %q\n", key, part.header[key])
+		}
+		fmt.Fprintf(h, "%d\n", part.size)
+		err := part.readContents(h)
+		if err != nil {
+			return "", errors.AddContext(err, fmt.Sprintf("could not hash file %v", part.filename))
+		}
+		fmt.Fprint(h, "\n")
+	}
+	return uniqueBoundary(h.Sum(nil), parts)
+}
+
+// uniqueBoundary derives a boundary from the given seed that none of the
+// given parts contain. If a part contains the boundary, the next one derived
+// from the seed is tried. Parts that can't be read in advance are checked
+// while they are written instead.
+func uniqueBoundary(seed []byte, parts []uploadPart) (string, error) {
+	for attempt := uint64(0); ; attempt++ {
+		boundary := boundaryCandidate(seed, attempt)
+		found := false
+		for _, part := range parts {
+			bw := &boundaryWriter{boundary: []byte(boundary)}
+			err := part.readContents(bw)
+			if err != nil {
+				return "", errors.AddContext(err, fmt.Sprintf("could not check file %v for the boundary", part.filename))
+			}
+			if bw.found {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return boundary, nil
+		}
+	}
+}
+
+// boundaryCandidate returns the boundary derived from seed for the given
+// attempt.
+func boundaryCandidate(seed []byte, attempt uint64) string {
+	h := sha256.New()
+	_, _ = h.Write(seed)
+	_ = binary.Write(h, binary.LittleEndian, attempt)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// readContents writes the contents of the part to w and rewinds its reader
+// afterwards. Nothing is written if the reader can't be rewound.
+func (part uploadPart) readContents(w io.Writer) error {
+	offset, seekable := readerOffset(part.reader)
+	if !seekable {
+		return nil
+	}
+	_, err := io.Copy(w, part.reader)
+	_, seekErr := part.reader.(io.Seeker).Seek(offset, io.SeekStart)
+	return errors.Compose(err, seekErr)
+}
+
+// readerOffset returns the current offset of r and whether r can be rewound to
+// it.
+func readerOffset(r io.Reader) (int64, bool) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	// Files such as pipes implement io.Seeker, but can't seek.
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	return offset, err == nil
+}
+
+// multipartLength returns the exact length of a multipart body containing the
+// given parts with the given boundary, or -1 if the size of a part is not
+// known.
+func multipartLength(parts []uploadPart, boundary string) (int64, error) {
+	var cw countingWriter
+	writer := multipart.NewWriter(&cw)
+	err := writer.SetBoundary(boundary)
+	if err != nil {
+		return 0, err
+	}
+	var dataLength int64
+	for _, part := range parts {
+		if part.size < 0 {
+			return -1, nil
+		}
+		_, err = writer.CreatePart(part.header)
+		if err != nil {
+			return 0, err
+		}
+		dataLength += part.size
+	}
+	err = writer.Close()
+	if err != nil {
+		return 0, err
+	}
+	return cw.n + dataLength, nil
+}
+
+// writeMultipart writes the given parts to the writer and closes it.
+func writeMultipart(writer *multipart.Writer, parts []uploadPart, tracker *progressTracker) error {
+	for _, part := range parts {
+		w, err := writer.CreatePart(part.header)
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("could not create form file for file %v", part.filename))
+		}
+		tracker.startFile(part.filename, part.size)
+		// Check the data for the boundary again, since parts that can't be
+		// rewound weren't checked in advance.
+		bw := &boundaryWriter{w: w, boundary: []byte(writer.Boundary())}
+		n, err := io.Copy(bw, &fileProgressReader{Reader: part.reader, tracker: tracker})
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("could not copy data for file %v", part.filename))
+		}
+		// The Content-Length was computed from the size, so the size must
+		// still be correct.
+		if part.size >= 0 && n != part.size {
+			return fmt.Errorf("size of file %v changed during upload: expected %v bytes, got %v", part.filename, part.size, n)
+		}
+	}
+	return errors.AddContext(writer.Close(), "could not close writer")
+}
+
+// formFileHeader is based on multipart.Writer.CreateFormFile, except it
+// properly sets the content type.
+func formFileHeader(fieldname, filename, contentType string) textproto.MIMEHeader {
+	escapeQuotes := func(s string) string {
+		var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+		return quoteEscaper.Replace(s)
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(fieldname), escapeQuotes(filename)))
+	h.Set("Content-Type", contentType)
+	return h
+}
+
+// unixMode converts the given file mode to a Unix mode. Regular files only
+// have their permission bits set, other files also have the file type bits
+// that stat would report for them.
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	switch {
+	case mode&os.ModeSymlink != 0:
+		m |= 0120000
+	case mode&os.ModeDir != 0:
+		m |= 040000
+	case mode&os.ModeNamedPipe != 0:
+		m |= 010000
+	case mode&os.ModeSocket != 0:
+		m |= 0140000
+	case mode&os.ModeCharDevice != 0:
+		m |= 020000
+	case mode&os.ModeDevice != 0:
+		m |= 060000
+	}
+	return m
+}
+
+// readerSize returns the number of bytes left in the given reader, or -1 if
+// it can't be determined without reading.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	default:
+		return -1
+	}
+}
+
+// Write implements io.Writer.
+func (bw *boundaryWriter) Write(p []byte) (int, error) {
+	if !bw.found {
+		// Look for a boundary starting in the end of the previous writes
+		// before looking in p.
+		keep := len(bw.boundary) - 1
+		start := p
+		if len(start) > keep {
+			start = start[:keep]
+		}
+		bw.tail = append(bw.tail, start...)
+		bw.found = bytes.Contains(bw.tail, bw.boundary) || bytes.Contains(p, bw.boundary)
+		if len(p) >= keep {
+			bw.tail = append(bw.tail[:0], p[len(p)-keep:]...)
+		} else if len(bw.tail) > keep {
+			bw.tail = append(bw.tail[:0], bw.tail[len(bw.tail)-keep:]...)
+		}
+	}
+	if bw.w == nil {
+		return len(p), nil
+	}
+	if bw.found {
+		return 0, errBoundaryInContents
+	}
+	return bw.w.Write(p)
+}
+
+// Write implements io.Writer.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// Read implements io.Reader.
+func (fpr *fileProgressReader) Read(p []byte) (int, error) {
+	n, err := fpr.Reader.Read(p)
+	fpr.tracker.addFile(int64(n))
+	return n, err
+}
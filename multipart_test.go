@@ -0,0 +1,94 @@
+package skynet
+
+import (
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestMultipartBoundary tests that boundaries are derived from the parts and
+// never contained in them.
+func TestMultipartBoundary(t *testing.T) {
+	newPart := func(filename string, r io.Reader) uploadPart {
+		part, err := newUploadPart("files[]", UploadEntry{Filename: filename, Reader: r}, DefaultUploadOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return part
+	}
+
+	// The boundary depends on the contents of the parts.
+	b1, err := multipartBoundary([]uploadPart{newPart("a", strings.NewReader("foo"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := multipartBoundary([]uploadPart{newPart("a", strings.NewReader("foo"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b3, err := multipartBoundary([]uploadPart{newPart("a", strings.NewReader("bar"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b1 != b2 || b1 == b3 {
+		t.Fatalf("expected boundaries to depend on the contents, got %v, %v and %v", b1, b2, b3)
+	}
+
+	// A boundary contained in a part is derived again. The boundary starts
+	// in the data read to detect the content type.
+	seed := []byte("seed")
+	collision := boundaryCandidate(seed, 0)
+	contents := strings.Repeat("x", 510) + collision
+	part := newPart("a", strings.NewReader(contents))
+	boundary, err := uniqueBoundary(seed, []uploadPart{part})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boundary != boundaryCandidate(seed, 1) {
+		t.Fatalf("expected boundary %v, got %v", boundaryCandidate(seed, 1), boundary)
+	}
+	data, err := ioutil.ReadAll(part.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != contents {
+		t.Fatal("checking the part for the boundary didn't rewind it")
+	}
+
+	// Parts that can't be rewound are checked while they are written.
+	part = newPart("a", io.MultiReader(strings.NewReader(contents)))
+	writer := multipart.NewWriter(ioutil.Discard)
+	err = writer.SetBoundary(collision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeMultipart(writer, []uploadPart{part}, nil)
+	if !errors.Contains(err, errBoundaryInContents) {
+		t.Fatalf("expected %v, got %v", errBoundaryInContents, err)
+	}
+}
+
+// TestUnixMode tests converting file modes to Unix modes.
+func TestUnixMode(t *testing.T) {
+	tests := []struct {
+		mode     os.FileMode
+		expected uint32
+	}{
+		{0644, 0644},
+		{os.ModeSetuid | os.ModeSticky | 0755, 05755},
+		{os.ModeSymlink | 0777, 0120777},
+		{os.ModeDir | 0700, 040700},
+		{os.ModeDevice | os.ModeCharDevice | 0600, 020600},
+		{os.ModeDevice | 0600, 060600},
+	}
+	for _, test := range tests {
+		if mode := unixMode(test.mode); mode != test.expected {
+			t.Errorf("mode %v: expected %o, got %o", test.mode, test.expected, mode)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// PinOptions contains the options used for pinning skylinks.
+	PinOptions struct {
+		Options
+
+		// SiaPath is the path the skyfile is pinned under, relative to the
+		// skynet folder of the node. If this is empty, the node picks a
+		// random path.
+		SiaPath string
+		// Force overwrites an existing file at SiaPath.
+		Force bool
+	}
+
+	// UnpinOptions contains the options used for unpinning skylinks.
+	UnpinOptions struct {
+		Options
+
+		// SiaPath is the full path of the pinned skyfile, such as
+		// "var/skynet/file". If it is set, the skyfile at the path is deleted
+		// in addition to unpinning the skylink.
+		SiaPath string
+	}
+
+	// PinResponse contains the response for pinning a skylink.
+	PinResponse struct {
+		// SiaPath is the full path the skyfile was pinned under.
+		SiaPath string `json:"siapath"`
+	}
+)
+
+var (
+	// DefaultPinOptions contains the default pin options.
+	DefaultPinOptions = PinOptions{
+		Options: DefaultOptions("/skynet/pin"),
+
+		SiaPath: "",
+		Force:   false,
+	}
+	// DefaultUnpinOptions contains the default unpin options.
+	DefaultUnpinOptions = UnpinOptions{
+		Options: DefaultOptions("/skynet/unpin"),
+
+		SiaPath: "",
+	}
+)
+
+// Pin pins the given skylink to the portal or node, so that its data is kept
+// available even if the original uploader stops paying for it. It returns the
+// siapath the skyfile was pinned under. The error contains ErrNotFound if the
+// skylink doesn't exist.
+func (sc *SkynetClient) Pin(skylink string, opts PinOptions) (siaPath string, err error) {
+	skylink = strings.TrimPrefix(skylink, URISkynetPrefix)
+
+	values := url.Values{}
+	if opts.SiaPath != "" {
+		values.Set("siapath", opts.SiaPath)
+	}
+	if opts.Force {
+		values.Set("force", "true")
+	}
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:   opts.Options,
+			method:    "POST",
+			reqBody:   &bytes.Buffer{},
+			extraPath: skylink,
+			query:     values,
+		},
+	)
+	if err != nil {
+		return "", errors.AddContext(err, "could not execute request")
+	}
+
+	respBody, err := parseResponseBody(resp)
+	if err != nil {
+		return "", errors.AddContext(err, "could not parse response body")
+	}
+
+	var apiResponse PinResponse
+	err = json.Unmarshal(respBody.Bytes(), &apiResponse)
+	if err != nil {
+		return "", errors.AddContext(err, "could not unmarshal response JSON")
+	}
+
+	return apiResponse.SiaPath, nil
+}
+
+// Unpin unpins the given skylink from the portal or node. The error contains
+// ErrNotFound if the skylink doesn't exist.
+func (sc *SkynetClient) Unpin(skylink string, opts UnpinOptions) error {
+	skylink = strings.TrimPrefix(skylink, URISkynetPrefix)
+
+	values := url.Values{}
+	if opts.SiaPath != "" {
+		values.Set("siapath", opts.SiaPath)
+	}
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:   opts.Options,
+			method:    "POST",
+			reqBody:   &bytes.Buffer{},
+			extraPath: skylink,
+			query:     values,
+		},
+	)
+	if err != nil {
+		return errors.AddContext(err, "could not execute request")
+	}
+	_, err = parseResponseBody(resp)
+	if err != nil {
+		return errors.AddContext(err, "could not parse response body")
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package skynet
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	// ProgressPhase is the phase of an upload or download.
+	ProgressPhase int
+
+	// Progress contains the progress of an upload or download.
+	Progress struct {
+		// Phase is the current phase of the transfer.
+		Phase ProgressPhase
+
+		// BytesTransferred is the number of bytes sent or received so far.
+		BytesTransferred int64
+		// TotalBytes is the total number of bytes to transfer, or -1 if it is
+		// not known.
+		TotalBytes int64
+
+		// Filename is the name of the file currently being sent. It is only
+		// set for uploads.
+		Filename string
+		// FileBytesTransferred is the number of bytes of the current file that
+		// have been sent so far.
+		FileBytesTransferred int64
+		// FileTotalBytes is the size of the current file, or -1 if it is not
+		// known.
+		FileTotalBytes int64
+	}
+
+	// ProgressFunc is a callback that receives progress updates.
+	ProgressFunc func(Progress)
+
+	// progressTracker keeps track of the progress of a transfer and reports it
+	// to a ProgressFunc. Byte updates are throttled, phase changes are always
+	// reported. The ProgressFunc is called without holding the lock, so that
+	// it may cancel the transfer. A nil tracker is valid and does nothing.
+	progressTracker struct {
+		fn         ProgressFunc
+		progress   Progress
+		lastReport time.Time
+		mu         sync.Mutex
+	}
+
+	// progressReader is an io.Reader that reports the bytes read from it to a
+	// progressTracker.
+	progressReader struct {
+		io.Reader
+		tracker *progressTracker
+		// eofPhase is the phase to switch to once the reader is exhausted.
+		eofPhase ProgressPhase
+	}
+
+	// progressReadCloser is a progressReader that also closes the underlying
+	// reader.
+	progressReadCloser struct {
+		progressReader
+		closer io.Closer
+	}
+)
+
+const (
+	// ProgressPhasePreparing means the request is being prepared.
+	ProgressPhasePreparing ProgressPhase = iota
+	// ProgressPhaseSending means the request body is being sent.
+	ProgressPhaseSending
+	// ProgressPhaseWaiting means the request has been sent and we are waiting
+	// for the portal to respond.
+	ProgressPhaseWaiting
+	// ProgressPhaseReceiving means the response body is being received.
+	ProgressPhaseReceiving
+	// ProgressPhaseDone means the transfer is complete.
+	ProgressPhaseDone
+)
+
+const (
+	// progressInterval is the minimum interval between two byte updates sent
+	// to a ProgressFunc.
+	progressInterval = 100 * time.Millisecond
+)
+
+// String implements fmt.Stringer.
+func (p ProgressPhase) String() string {
+	switch p {
+	case ProgressPhasePreparing:
+		return "preparing"
+	case ProgressPhaseSending:
+		return "sending"
+	case ProgressPhaseWaiting:
+		return "waiting"
+	case ProgressPhaseReceiving:
+		return "receiving"
+	case ProgressPhaseDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// newProgressTracker creates a new progress tracker. Returns nil if fn is nil.
+func newProgressTracker(fn ProgressFunc) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	return &progressTracker{
+		fn: fn,
+		progress: Progress{
+			TotalBytes:     -1,
+			FileTotalBytes: -1,
+		},
+	}
+}
+
+// setPhase sets the current phase and reports it.
+func (pt *progressTracker) setPhase(phase ProgressPhase) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	if pt.progress.Phase == phase && phase != ProgressPhasePreparing {
+		pt.mu.Unlock()
+		return
+	}
+	pt.progress.Phase = phase
+	progress := pt.reportLocked()
+	pt.mu.Unlock()
+	pt.fn(progress)
+}
+
+// setTotal sets the total number of bytes to transfer and resets the number
+// of transferred bytes.
+func (pt *progressTracker) setTotal(total int64) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.progress.BytesTransferred = 0
+	pt.progress.TotalBytes = total
+}
+
+// setTransferred sets the number of transferred bytes of both the transfer
+// and the current file. It is used when a transfer is resumed or retried.
+func (pt *progressTracker) setTransferred(n int64) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.progress.BytesTransferred = n
+	pt.progress.FileBytesTransferred = n
+}
+
+// startFile marks the start of a new file and reports it.
+func (pt *progressTracker) startFile(filename string, size int64) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	pt.progress.Filename = filename
+	pt.progress.FileBytesTransferred = 0
+	pt.progress.FileTotalBytes = size
+	progress := pt.reportLocked()
+	pt.mu.Unlock()
+	pt.fn(progress)
+}
+
+// add adds n transferred bytes, reporting them if enough time has passed
+// since the last report.
+func (pt *progressTracker) add(n int64) {
+	if pt == nil || n == 0 {
+		return
+	}
+	pt.mu.Lock()
+	pt.progress.BytesTransferred += n
+	progress, report := pt.maybeReportLocked()
+	pt.mu.Unlock()
+	if report {
+		pt.fn(progress)
+	}
+}
+
+// addFile adds n transferred bytes of the current file, reporting them if
+// enough time has passed since the last report. The bytes are not added to
+// the total, use add for that.
+func (pt *progressTracker) addFile(n int64) {
+	if pt == nil || n == 0 {
+		return
+	}
+	pt.mu.Lock()
+	pt.progress.FileBytesTransferred += n
+	progress, report := pt.maybeReportLocked()
+	pt.mu.Unlock()
+	if report {
+		pt.fn(progress)
+	}
+}
+
+// maybeReportLocked returns the current progress and whether it should be
+// reported, which is the case if enough time has passed since the last
+// report. The caller must hold the lock.
+func (pt *progressTracker) maybeReportLocked() (Progress, bool) {
+	if time.Since(pt.lastReport) < progressInterval {
+		return Progress{}, false
+	}
+	return pt.reportLocked(), true
+}
+
+// reportLocked records a report and returns the current progress, which the
+// caller passes to the ProgressFunc after releasing the lock. The caller must
+// hold the lock.
+func (pt *progressTracker) reportLocked() Progress {
+	pt.lastReport = time.Now()
+	return pt.progress
+}
+
+// newProgressReader wraps r so that bytes read from it are reported to the
+// tracker. Once r is exhausted the tracker switches to eofPhase.
+func newProgressReader(r io.Reader, tracker *progressTracker, eofPhase ProgressPhase) *progressReader {
+	return &progressReader{
+		Reader:   r,
+		tracker:  tracker,
+		eofPhase: eofPhase,
+	}
+}
+
+// Read implements io.Reader.
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.Reader.Read(p)
+	pr.tracker.add(int64(n))
+	if err == io.EOF {
+		pr.tracker.setPhase(pr.eofPhase)
+	}
+	return n, err
+}
+
+// newProgressReadCloser wraps rc so that bytes read from it are reported to
+// the tracker.
+func newProgressReadCloser(rc io.ReadCloser, tracker *progressTracker, eofPhase ProgressPhase) io.ReadCloser {
+	if tracker == nil {
+		return rc
+	}
+	return &progressReadCloser{
+		progressReader: *newProgressReader(rc, tracker, eofPhase),
+		closer:         rc,
+	}
+}
+
+// Close implements io.Closer.
+func (prc *progressReadCloser) Close() error {
+	return prc.closer.Close()
+}
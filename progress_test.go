@@ -0,0 +1,24 @@
+package skynet
+
+import (
+	"testing"
+)
+
+// TestProgressTrackerReentrant tests that a ProgressFunc may update the
+// tracker that reports to it, which happens when it cancels the transfer.
+func TestProgressTrackerReentrant(t *testing.T) {
+	var pt *progressTracker
+	var phases []ProgressPhase
+	pt = newProgressTracker(func(p Progress) {
+		phases = append(phases, p.Phase)
+		if p.Phase == ProgressPhaseSending {
+			pt.setPhase(ProgressPhaseDone)
+		}
+	})
+	pt.setPhase(ProgressPhaseSending)
+
+	expected := []ProgressPhase{ProgressPhaseSending, ProgressPhaseDone}
+	if len(phases) != len(expected) || phases[0] != expected[0] || phases[1] != expected[1] {
+		t.Fatalf("expected phases %v, got %v", expected, phases)
+	}
+}
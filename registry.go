@@ -0,0 +1,369 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// RegistryEntry is an entry of the registry. Entries are identified by
+	// the public key of their owner and their data key, and only the owner
+	// can update them by signing an entry with a higher revision.
+	RegistryEntry struct {
+		// DataKey is the key of the entry, such as the name of a file. It is
+		// hashed before it is sent to the portal.
+		DataKey string
+		// Data is the data of the entry, which is at most
+		// MaxRegistryDataSize bytes long.
+		Data []byte
+		// Revision is the revision of the entry. Entries can only be
+		// replaced by entries with a higher revision.
+		Revision uint64
+		// Signature is the signature of the entry by its owner. It is set by
+		// GetEntry and ignored by SetEntry, which signs the entry.
+		Signature []byte
+	}
+
+	// GetEntryOptions contains the options used for getting registry
+	// entries.
+	GetEntryOptions struct {
+		Options
+
+		// Timeout is the time the portal looks for the entry before it
+		// gives up. It is rounded up to whole seconds. If it is zero, the
+		// portal's default is used.
+		Timeout time.Duration
+	}
+
+	// SetEntryOptions contains the options used for setting registry
+	// entries.
+	SetEntryOptions struct {
+		Options
+	}
+
+	// UpdateEntryOptions contains the options used for updating registry
+	// entries.
+	UpdateEntryOptions struct {
+		// GetEntryOptions are used to get the current entry.
+		GetEntryOptions GetEntryOptions
+		// SetEntryOptions are used to set the updated entry.
+		SetEntryOptions SetEntryOptions
+
+		// MaxRetries is the number of times an update is retried after a
+		// revision conflict before UpdateEntry gives up.
+		MaxRetries int
+		// RetryInterval is the average time to wait before the first retry.
+		// It is doubled with every further retry, and the actual wait is
+		// randomized so that competing writers don't retry in lockstep.
+		RetryInterval time.Duration
+	}
+
+	// registryEntryGET is the response for getting a registry entry.
+	registryEntryGET struct {
+		Data      string `json:"data"`
+		DataKey   string `json:"datakey"`
+		Revision  uint64 `json:"revision"`
+		Signature string `json:"signature"`
+		Type      uint8  `json:"type"`
+	}
+
+	// registryEntryPOST is the request for setting a registry entry.
+	registryEntryPOST struct {
+		PublicKey registryPublicKey `json:"publickey"`
+		DataKey   string            `json:"datakey"`
+		Revision  uint64            `json:"revision"`
+		Signature [64]byte          `json:"signature"`
+		Data      []byte            `json:"data"`
+	}
+
+	// registryPublicKey is the JSON encoding of public keys in registry
+	// requests.
+	registryPublicKey struct {
+		Algorithm string `json:"algorithm"`
+		Key       []byte `json:"key"`
+	}
+)
+
+const (
+	// MaxRegistryDataSize is the maximum size of the data of a registry
+	// entry.
+	MaxRegistryDataSize = 113
+
+	// registryTypeWithoutPubkey is the type of entries set by the SDK, whose
+	// data is arbitrary.
+	registryTypeWithoutPubkey = 1
+	// registryTypeWithPubkey is the type of entries that start with the hash
+	// of a host's public key. Their type is part of the signed data.
+	registryTypeWithPubkey = 2
+)
+
+// registryRevisionErrors contains the messages of the errors returned by
+// portals for entries that don't replace the existing entry.
+var registryRevisionErrors = []string{
+	"provided revision number is invalid",
+	"provided revision number is already registered",
+	"entry doesn't have enough pow to replace existing entry",
+}
+
+var (
+	// DefaultGetEntryOptions contains the default get entry options.
+	DefaultGetEntryOptions = GetEntryOptions{
+		Options: DefaultOptions("/skynet/registry"),
+
+		Timeout: 0,
+	}
+	// DefaultSetEntryOptions contains the default set entry options.
+	DefaultSetEntryOptions = SetEntryOptions{
+		Options: DefaultOptions("/skynet/registry"),
+	}
+	// DefaultUpdateEntryOptions contains the default update entry options.
+	DefaultUpdateEntryOptions = UpdateEntryOptions{
+		GetEntryOptions: DefaultGetEntryOptions,
+		SetEntryOptions: DefaultSetEntryOptions,
+
+		MaxRetries:    5,
+		RetryInterval: 200 * time.Millisecond,
+	}
+
+	// ErrRegistryEntryNotFound is returned when the registry doesn't contain
+	// the requested entry, or the portal couldn't find it in time.
+	ErrRegistryEntryNotFound = errors.New("registry entry not found")
+	// ErrRegistryTimeout is returned when the portal couldn't update a
+	// registry entry in time.
+	ErrRegistryTimeout = errors.New("registry request timed out")
+	// ErrRegistryRevisionConflict is returned when setting a registry entry
+	// whose revision is not higher than the revision of the existing entry,
+	// usually because another writer updated the entry.
+	ErrRegistryRevisionConflict = errors.New("registry entry revision conflict")
+	// ErrInvalidRegistrySignature is returned for registry entries whose
+	// signature wasn't made by the owner of the entry.
+	ErrInvalidRegistrySignature = errors.New("invalid registry entry signature")
+	// ErrRegistryUpdateConflict is returned by UpdateEntry when every attempt
+	// to update an entry conflicted with another writer.
+	ErrRegistryUpdateConflict = errors.New("registry entry kept being updated by another writer")
+)
+
+// GetEntry gets the registry entry of publicKey with the given data key. The
+// signature of the entry is verified. The error contains
+// ErrRegistryEntryNotFound if the entry doesn't exist.
+func (sc *SkynetClient) GetEntry(publicKey PublicKey, dataKey string, opts GetEntryOptions) (RegistryEntry, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return RegistryEntry{}, fmt.Errorf("public key must be %v bytes, got %v", ed25519.PublicKeySize, len(publicKey))
+	}
+	hashedDataKey := hashDataKey(dataKey)
+	values := url.Values{}
+	values.Set("publickey", publicKey.String())
+	values.Set("datakey", hex.EncodeToString(hashedDataKey))
+	if opts.Timeout > 0 {
+		seconds := (opts.Timeout + time.Second - 1) / time.Second
+		values.Set("timeout", strconv.FormatInt(int64(seconds), 10))
+	}
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			method:  "GET",
+			reqBody: &bytes.Buffer{},
+			query:   values,
+		},
+	)
+	if err != nil {
+		return RegistryEntry{}, errors.AddContext(registryError(err), "could not execute request")
+	}
+
+	respBody, err := parseResponseBody(resp)
+	if err != nil {
+		return RegistryEntry{}, errors.AddContext(err, "could not parse response body")
+	}
+	var response registryEntryGET
+	err = json.Unmarshal(respBody.Bytes(), &response)
+	if err != nil {
+		return RegistryEntry{}, errors.AddContext(err, "could not unmarshal response JSON")
+	}
+
+	if response.DataKey != hex.EncodeToString(hashedDataKey) {
+		return RegistryEntry{}, fmt.Errorf("portal returned entry with data key %v instead of %x", response.DataKey, hashedDataKey)
+	}
+	entry := RegistryEntry{DataKey: dataKey, Revision: response.Revision}
+	entry.Data, err = hex.DecodeString(response.Data)
+	if err != nil {
+		return RegistryEntry{}, errors.AddContext(err, "could not decode data")
+	}
+	entry.Signature, err = hex.DecodeString(response.Signature)
+	if err != nil {
+		return RegistryEntry{}, errors.AddContext(err, "could not decode signature")
+	}
+	switch response.Type {
+	case 0:
+		// Portals that don't return the type only support entries without
+		// a public key.
+		response.Type = registryTypeWithoutPubkey
+	case registryTypeWithoutPubkey, registryTypeWithPubkey:
+	default:
+		return RegistryEntry{}, fmt.Errorf("unsupported registry entry type %v", response.Type)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), entry.hash(response.Type), entry.Signature) {
+		return RegistryEntry{}, ErrInvalidRegistrySignature
+	}
+	return entry, nil
+}
+
+// SetEntry signs the given entry with privateKey and sets it in the registry.
+// The revision of the entry must be higher than the revision of the existing
+// entry, if any, otherwise the error contains ErrRegistryRevisionConflict. The
+// error contains ErrRegistryTimeout if the portal couldn't update the entry in
+// time.
+func (sc *SkynetClient) SetEntry(privateKey ed25519.PrivateKey, entry RegistryEntry, opts SetEntryOptions) error {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("private key must be %v bytes, got %v", ed25519.PrivateKeySize, len(privateKey))
+	}
+	if len(entry.Data) > MaxRegistryDataSize {
+		return fmt.Errorf("registry data is %v bytes, but at most %v bytes are allowed", len(entry.Data), MaxRegistryDataSize)
+	}
+	request := registryEntryPOST{
+		PublicKey: registryPublicKey{
+			Algorithm: publicKeyAlgorithm,
+			Key:       privateKey.Public().(ed25519.PublicKey),
+		},
+		DataKey:  hex.EncodeToString(hashDataKey(entry.DataKey)),
+		Revision: entry.Revision,
+		Data:     entry.Data,
+	}
+	copy(request.Signature[:], ed25519.Sign(privateKey, entry.hash(registryTypeWithoutPubkey)))
+	body, err := json.Marshal(request)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal request")
+	}
+
+	opts.customContentType = "application/json"
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			method:  "POST",
+			reqBody: bytes.NewReader(body),
+		},
+	)
+	if err != nil {
+		return errors.AddContext(registryError(err), "could not execute request")
+	}
+	_, err = parseResponseBody(resp)
+	return errors.AddContext(err, "could not parse response body")
+}
+
+// UpdateEntry updates the registry entry of privateKey with the given data
+// key. It gets the current entry, calls update with its data, which is nil if
+// the entry doesn't exist, and sets the returned data with the next revision.
+// If another writer updated the entry in the meantime, the update is retried
+// with the new data after a while. If update fails, its error is returned
+// without retrying. If the update conflicted MaxRetries+1 times, the error
+// contains ErrRegistryUpdateConflict. The entry that was set is returned.
+func (sc *SkynetClient) UpdateEntry(privateKey ed25519.PrivateKey, dataKey string, update func(old []byte) ([]byte, error), opts UpdateEntryOptions) (RegistryEntry, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return RegistryEntry{}, fmt.Errorf("private key must be %v bytes, got %v", ed25519.PrivateKeySize, len(privateKey))
+	}
+	publicKey := PublicKey(privateKey.Public().(ed25519.PublicKey))
+	retryInterval := opts.RetryInterval
+	for retries := 0; ; retries++ {
+		entry, err := sc.GetEntry(publicKey, dataKey, opts.GetEntryOptions)
+		if errors.Contains(err, ErrRegistryEntryNotFound) {
+			entry, err = RegistryEntry{DataKey: dataKey}, nil
+		} else if err == nil {
+			if entry.Revision == math.MaxUint64 {
+				return RegistryEntry{}, errors.New("registry entry has the maximum revision")
+			}
+			entry.Revision++
+		}
+		if err != nil {
+			return RegistryEntry{}, errors.AddContext(err, "could not get registry entry")
+		}
+
+		entry.Data, err = update(entry.Data)
+		if err != nil {
+			return RegistryEntry{}, errors.AddContext(err, "could not update registry entry")
+		}
+		entry.Signature = nil
+		err = sc.SetEntry(privateKey, entry, opts.SetEntryOptions)
+		if err == nil {
+			sc.revisions.update(publicKey, dataKey, entry.Revision)
+			entry.Signature = ed25519.Sign(privateKey, entry.hash(registryTypeWithoutPubkey))
+			return entry, nil
+		}
+		if !errors.Contains(err, ErrRegistryRevisionConflict) {
+			return RegistryEntry{}, errors.AddContext(err, "could not set registry entry")
+		}
+		sc.revisions.remove(publicKey, dataKey)
+
+		// Retry with the entry of the other writer, after a while.
+		if retries >= opts.MaxRetries {
+			return RegistryEntry{}, errors.AddContext(errors.Compose(ErrRegistryUpdateConflict, err), fmt.Sprintf("could not update registry entry in %v attempts", retries+1))
+		}
+		time.Sleep(jitter(retryInterval))
+		retryInterval *= 2
+	}
+}
+
+// hash returns the hash of the entry that is signed by its owner, which is
+// the hash of the Sia encoding of the hashed data key, the data, the revision
+// and, for entries that start with a host's public key, the type.
+func (entry RegistryEntry) hash(entryType uint8) []byte {
+	var b bytes.Buffer
+	b.Write(hashDataKey(entry.DataKey))
+	_ = binary.Write(&b, binary.LittleEndian, uint64(len(entry.Data)))
+	b.Write(entry.Data)
+	_ = binary.Write(&b, binary.LittleEndian, entry.Revision)
+	if entryType == registryTypeWithPubkey {
+		_ = binary.Write(&b, binary.LittleEndian, uint64(entryType))
+	}
+	h := blake2b.Sum256(b.Bytes())
+	return h[:]
+}
+
+// hashDataKey hashes a data key the same way as skynet-js, which is the hash
+// of its Sia encoding.
+func hashDataKey(dataKey string) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint64(len(dataKey)))
+	b.WriteString(dataKey)
+	h := blake2b.Sum256(b.Bytes())
+	return h[:]
+}
+
+// jitter returns a random duration between half of d and 1.5 times d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// registryError adds the typed registry error to errors of registry requests.
+func registryError(err error) error {
+	switch {
+	case errors.Contains(err, ErrNotFound):
+		return errors.Compose(ErrRegistryEntryNotFound, err)
+	case errors.Contains(err, ErrRequestTimeout):
+		return errors.Compose(ErrRegistryTimeout, err)
+	case errors.Contains(err, ErrResponseError):
+		for _, message := range registryRevisionErrors {
+			if strings.Contains(err.Error(), message) {
+				return errors.Compose(ErrRegistryRevisionConflict, err)
+			}
+		}
+		return err
+	default:
+		return err
+	}
+}
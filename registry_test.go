@@ -0,0 +1,39 @@
+package skynet
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// TestRegistryEntrySignature tests signing registry entries against
+// signatures made by siad.
+func TestRegistryEntrySignature(t *testing.T) {
+	kp := KeyPairFromSeed("insecure test seed")
+	if dataKey := hex.EncodeToString(hashDataKey("app")); dataKey != "7c96a0537ab2aaac9cfe0eca217732f4e10791625b4ab4c17e4d91c8078713b9" {
+		t.Fatalf("unexpected hashed data key %v", dataKey)
+	}
+
+	tests := []struct {
+		entry     RegistryEntry
+		entryType uint8
+		signature string
+	}{
+		{
+			entry:     RegistryEntry{DataKey: "app", Data: []byte("hello"), Revision: 3},
+			entryType: registryTypeWithoutPubkey,
+			signature: "ead1d1a59d228876e2b0cead86d29c490b6f068704a28f5908fefa9621e779fb5c4598d92429229f7bf5a586562b1a5f5dabe6739aa89cd82146b8a4862ac600",
+		},
+		{
+			entry:     RegistryEntry{DataKey: "app", Data: []byte("0123456789012345678901234"), Revision: 3},
+			entryType: registryTypeWithPubkey,
+			signature: "36ff0f2c31223ba0326204db4e8b1e34de6e9f4018af0c5f6432da34195e93a6ebced32c64e7080b4471ac572d73ff35ad0adf3a40164c8132c39e0374e66107",
+		},
+	}
+	for _, test := range tests {
+		sig := ed25519.Sign(kp.PrivateKey, test.entry.hash(test.entryType))
+		if s := hex.EncodeToString(sig); s != test.signature {
+			t.Fatalf("expected signature %v, got %v", test.signature, s)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// shareMetadata is the metadata of a shared file. It is encrypted
+	// together with the file, so that portals can't see it.
+	shareMetadata struct {
+		Filename    string `json:"filename"`
+		ContentType string `json:"contenttype"`
+	}
+)
+
+const (
+	// shareFilename is the filename shared files are uploaded under. The
+	// real filename is part of the encrypted payload.
+	shareFilename = "encrypted"
+	// shareMaxMetadataSize is the largest size of the metadata of a shared
+	// file accepted when downloading.
+	shareMaxMetadataSize = 1 << 16
+)
+
+var (
+	// ErrInvalidShareLink is returned for share links that can't be parsed.
+	ErrInvalidShareLink = errors.New("invalid share link")
+)
+
+// UploadShareFile encrypts the file at path with a random key, uploads it and
+// returns a share link. See UploadShare.
+func (sc *SkynetClient) UploadShareFile(path string, opts UploadOptions) (shareLink string, err error) {
+	path = gopath.Clean(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.AddContext(err, fmt.Sprintf("could not open file %v", path))
+	}
+	defer func() {
+		err = errors.Extend(err, file.Close())
+	}()
+	return sc.UploadShare(file, filepath.Base(path), opts)
+}
+
+// UploadShare encrypts the data with a random key, uploads it and returns a
+// share link, which contains the key in its fragment. The filename and the
+// content type of the data are encrypted together with it, so the portal
+// only sees ciphertext. Share links can be downloaded with DownloadShare.
+func (sc *SkynetClient) UploadShare(data io.Reader, filename string, opts UploadOptions) (string, error) {
+	if filename == "" {
+		return "", errors.New("filename must be set")
+	}
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	// Prepend the metadata to the data.
+	size := readerSize(data)
+	contentType, head, err := fileContentType(filename, data, opts)
+	if err != nil {
+		return "", errors.AddContext(err, "could not detect content type")
+	}
+	metadata, err := json.Marshal(shareMetadata{Filename: filename, ContentType: contentType})
+	if err != nil {
+		return "", errors.AddContext(err, "could not marshal metadata")
+	}
+	prefix := make([]byte, 4, 4+len(metadata)+len(head))
+	binary.LittleEndian.PutUint32(prefix, uint32(len(metadata)))
+	prefix = append(prefix, metadata...)
+	prefix = append(prefix, head...)
+	entry := UploadEntry{
+		Filename: shareFilename,
+		Reader:   io.MultiReader(bytes.NewReader(prefix), data),
+	}
+	if size >= 0 {
+		entry.Size = int64(len(prefix)) + size - int64(len(head))
+	}
+
+	opts.EncryptionKey = key
+	opts.CustomFilename = ""
+	opts.CustomDirname = ""
+	skylink, err := sc.UploadEntries([]UploadEntry{entry}, opts)
+	if err != nil {
+		return "", err
+	}
+	skylink = strings.TrimPrefix(skylink, URISkynetPrefix)
+	fragment := base64.RawURLEncoding.EncodeToString(key.Key())
+	return fmt.Sprintf("%s/%s#%s", strings.TrimRight(sc.PortalURL, "/"), skylink, fragment), nil
+}
+
+// ParseShareLink returns the skylink and the key of the given share link.
+// Share links may also use the sia:// scheme instead of a portal URL.
+func ParseShareLink(shareLink string) (skylink string, key *EncryptionKey, err error) {
+	i := strings.IndexByte(shareLink, '#')
+	if i < 0 {
+		return "", nil, errors.AddContext(ErrInvalidShareLink, "share link has no key")
+	}
+	link, fragment := shareLink[:i], shareLink[i+1:]
+
+	if strings.HasPrefix(link, URISkynetPrefix) {
+		skylink = strings.TrimPrefix(link, URISkynetPrefix)
+	} else {
+		u, err := url.Parse(link)
+		if err != nil {
+			return "", nil, errors.Compose(ErrInvalidShareLink, err)
+		}
+		skylink = strings.Trim(u.Path, "/")
+	}
+	if len(skylink) != base64SkylinkSize || strings.Contains(skylink, "/") {
+		return "", nil, errors.AddContext(ErrInvalidShareLink, fmt.Sprintf("invalid skylink %q", skylink))
+	}
+
+	rawKey, err := base64.RawURLEncoding.DecodeString(fragment)
+	if err != nil {
+		return "", nil, errors.AddContext(ErrInvalidShareLink, "could not decode key")
+	}
+	key, err = NewEncryptionKey(rawKey)
+	if err != nil {
+		return "", nil, errors.Compose(ErrInvalidShareLink, err)
+	}
+	return skylink, key, nil
+}
+
+// DownloadShare downloads and decrypts the file of the given share link from
+// the client's portal. The filename and the content type of the result are
+// the ones of the shared file.
+func (sc *SkynetClient) DownloadShare(shareLink string, opts DownloadOptions) (DownloadResult, error) {
+	skylink, key, err := ParseShareLink(shareLink)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	opts.EncryptionKey = key
+	result, err := sc.DownloadWithResult(skylink, opts)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	metadata, n, err := readShareMetadata(result.Body)
+	if err != nil {
+		return DownloadResult{}, errors.Compose(err, result.Body.Close())
+	}
+	result.Filename = metadata.Filename
+	result.ContentType = metadata.ContentType
+	if result.ContentLength >= 0 {
+		result.ContentLength -= n
+	}
+	return result, nil
+}
+
+// DownloadShareFile downloads and decrypts the file of the given share link
+// to path. If path is a directory, the file is stored in it under the name
+// of the shared file.
+func (sc *SkynetClient) DownloadShareFile(path, shareLink string, opts DownloadOptions) (err error) {
+	result, err := sc.DownloadShare(shareLink, opts)
+	if err != nil {
+		return errors.AddContext(err, "could not download data")
+	}
+	defer func() {
+		err = errors.Extend(err, result.Body.Close())
+	}()
+
+	path = gopath.Clean(path)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		filename := filepath.Base(filepath.FromSlash(result.Filename))
+		if filename == "." || filename == ".." || filename == string(filepath.Separator) {
+			return fmt.Errorf("invalid filename %q", result.Filename)
+		}
+		path = filepath.Join(path, filename)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return errors.AddContext(err, "could not create file at "+path)
+	}
+	defer func() {
+		err = errors.Extend(err, out.Close())
+	}()
+
+	n, err := io.Copy(out, result.Body)
+	if err != nil {
+		return errors.AddContext(err, "could not copy data to file at "+path)
+	}
+	if result.ContentLength >= 0 && n != result.ContentLength {
+		return fmt.Errorf("expected %v bytes from %v, got %v", result.ContentLength, result.PortalURL, n)
+	}
+	return nil
+}
+
+// readShareMetadata reads the metadata at the start of a shared file. It also
+// returns the number of bytes read.
+func readShareMetadata(r io.Reader) (shareMetadata, int64, error) {
+	var size [4]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return shareMetadata{}, 0, errors.AddContext(err, "could not read metadata size")
+	}
+	metadataSize := binary.LittleEndian.Uint32(size[:])
+	if metadataSize > shareMaxMetadataSize {
+		return shareMetadata{}, 0, fmt.Errorf("metadata of %v bytes is too large", metadataSize)
+	}
+	b := make([]byte, metadataSize)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return shareMetadata{}, 0, errors.AddContext(err, "could not read metadata")
+	}
+	var metadata shareMetadata
+	err = json.Unmarshal(b, &metadata)
+	if err != nil {
+		return shareMetadata{}, 0, errors.AddContext(err, "could not unmarshal metadata")
+	}
+	return metadata, int64(len(size) + len(b)), nil
+}
@@ -0,0 +1,48 @@
+package skynet
+
+import (
+	"bytes"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestParseShareLink tests parsing share links.
+func TestParseShareLink(t *testing.T) {
+	const skylink = "XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"
+	const fragment = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+	rawKey := make([]byte, EncryptionKeySize)
+	for i := range rawKey {
+		rawKey[i] = byte(i)
+	}
+
+	valid := []string{
+		"https://siasky.net/" + skylink + "#" + fragment,
+		"https://siasky.net/" + skylink + "/#" + fragment,
+		URISkynetPrefix + skylink + "#" + fragment,
+	}
+	for _, link := range valid {
+		parsedSkylink, key, err := ParseShareLink(link)
+		if err != nil {
+			t.Fatal(link, err)
+		}
+		if parsedSkylink != skylink || !bytes.Equal(key.Key(), rawKey) {
+			t.Fatalf("%v: unexpected skylink %v or key %x", link, parsedSkylink, key.Key())
+		}
+	}
+
+	invalid := []string{
+		"https://siasky.net/" + skylink,
+		"https://siasky.net/" + skylink + "#",
+		"https://siasky.net/" + skylink + "#" + fragment[1:],
+		"https://siasky.net/" + skylink + "#" + fragment + "!",
+		"https://siasky.net/" + skylink + "/file#" + fragment,
+		"https://siasky.net/#" + fragment,
+	}
+	for _, link := range invalid {
+		_, _, err := ParseShareLink(link)
+		if !errors.Contains(err, ErrInvalidShareLink) {
+			t.Fatalf("%v: expected %v, got %v", link, ErrInvalidShareLink, err)
+		}
+	}
+}
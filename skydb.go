@@ -0,0 +1,233 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// GetJSONOptions contains the options used for getting JSON documents
+	// from SkyDB.
+	GetJSONOptions struct {
+		// GetEntryOptions are used to get the registry entry.
+		GetEntryOptions GetEntryOptions
+		// DownloadOptions are used to download the document.
+		DownloadOptions DownloadOptions
+	}
+
+	// SetJSONOptions contains the options used for setting JSON documents in
+	// SkyDB.
+	SetJSONOptions struct {
+		// GetEntryOptions are used to get the revision of the registry entry
+		// if it isn't cached.
+		GetEntryOptions GetEntryOptions
+		// SetEntryOptions are used to set the registry entry.
+		SetEntryOptions SetEntryOptions
+		// UploadOptions are used to upload the document.
+		UploadOptions UploadOptions
+	}
+
+	// skydbDocument is a JSON document as it is uploaded by skynet-js.
+	skydbDocument struct {
+		Data    json.RawMessage `json:"_data"`
+		Version int             `json:"_v"`
+	}
+
+	// revisionCache caches the latest known revisions of registry entries by
+	// public key and data key.
+	revisionCache struct {
+		revisions map[string]uint64
+		mu        sync.Mutex
+	}
+)
+
+const (
+	// skydbVersion is the version of the documents uploaded by SkyDB.
+	skydbVersion = 2
+)
+
+var (
+	// DefaultGetJSONOptions contains the default get JSON options.
+	DefaultGetJSONOptions = GetJSONOptions{
+		GetEntryOptions: DefaultGetEntryOptions,
+		DownloadOptions: DefaultDownloadOptions,
+	}
+	// DefaultSetJSONOptions contains the default set JSON options.
+	DefaultSetJSONOptions = SetJSONOptions{
+		GetEntryOptions: DefaultGetEntryOptions,
+		SetEntryOptions: DefaultSetEntryOptions,
+		UploadOptions:   DefaultUploadOptions,
+	}
+)
+
+// GetJSON gets the JSON document stored in SkyDB by publicKey under dataKey
+// and unmarshals it into v. The registry entry of dataKey contains the skylink
+// of the document. The error contains ErrRegistryEntryNotFound if the
+// document doesn't exist. Documents set by skynet-js can be read as well.
+func (sc *SkynetClient) GetJSON(publicKey PublicKey, dataKey string, v interface{}, opts GetJSONOptions) error {
+	entry, err := sc.GetEntry(publicKey, dataKey, opts.GetEntryOptions)
+	if err != nil {
+		return errors.AddContext(err, "could not get registry entry")
+	}
+	sc.revisions.update(publicKey, dataKey, entry.Revision)
+	skylink, err := skylinkFromEntryData(entry.Data)
+	if err != nil {
+		return err
+	}
+
+	r, err := sc.Download(skylink, opts.DownloadOptions)
+	if err != nil {
+		return errors.AddContext(err, "could not download document")
+	}
+	data, err := ioutil.ReadAll(r)
+	err = errors.Compose(err, r.Close())
+	if err != nil {
+		return errors.AddContext(err, "could not read document")
+	}
+
+	// Documents without a version are stored as they are.
+	var document skydbDocument
+	err = json.Unmarshal(data, &document)
+	if err == nil && document.Version == skydbVersion {
+		data = document.Data
+	}
+	return errors.AddContext(json.Unmarshal(data, v), "could not unmarshal document")
+}
+
+// SetJSON uploads v as a JSON document and stores its skylink in SkyDB under
+// dataKey, so that it can be read by anyone with the public key of
+// privateKey. The registry entry is set with the revision following the
+// latest revision known to the client. If another writer updated the entry
+// since then, the error contains ErrRegistryRevisionConflict and the next call
+// reads the revision from the portal again.
+func (sc *SkynetClient) SetJSON(privateKey ed25519.PrivateKey, dataKey string, v interface{}, opts SetJSONOptions) error {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("private key must be %v bytes, got %v", ed25519.PrivateKeySize, len(privateKey))
+	}
+	publicKey := PublicKey(privateKey.Public().(ed25519.PublicKey))
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal document")
+	}
+	document, err := json.Marshal(skydbDocument{Data: data, Version: skydbVersion})
+	if err != nil {
+		return errors.AddContext(err, "could not marshal document")
+	}
+
+	revision, err := sc.nextRevision(publicKey, dataKey, opts.GetEntryOptions)
+	if err != nil {
+		return err
+	}
+	// Use the same filename as skynet-js, which is based on the hashed data
+	// key.
+	filename := "dk:" + hex.EncodeToString(hashDataKey(dataKey))
+	skylink, err := sc.Upload(UploadData{filename: bytes.NewReader(document)}, opts.UploadOptions)
+	if err != nil {
+		return errors.AddContext(err, "could not upload document")
+	}
+	rawSkylink, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(skylink, URISkynetPrefix))
+	if err != nil {
+		return errors.AddContext(err, "could not decode skylink")
+	}
+
+	entry := RegistryEntry{DataKey: dataKey, Data: rawSkylink, Revision: revision}
+	err = sc.SetEntry(privateKey, entry, opts.SetEntryOptions)
+	if errors.Contains(err, ErrRegistryRevisionConflict) {
+		sc.revisions.remove(publicKey, dataKey)
+	}
+	if err != nil {
+		return errors.AddContext(err, "could not set registry entry")
+	}
+	sc.revisions.update(publicKey, dataKey, revision)
+	return nil
+}
+
+// nextRevision returns the revision following the latest known revision of
+// the registry entry. The revision is read from the portal if it isn't cached.
+func (sc *SkynetClient) nextRevision(publicKey PublicKey, dataKey string, opts GetEntryOptions) (uint64, error) {
+	revision, cached := sc.revisions.get(publicKey, dataKey)
+	if !cached {
+		entry, err := sc.GetEntry(publicKey, dataKey, opts)
+		if errors.Contains(err, ErrRegistryEntryNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, errors.AddContext(err, "could not get revision of registry entry")
+		}
+		revision = entry.Revision
+		sc.revisions.update(publicKey, dataKey, revision)
+	}
+	if revision == math.MaxUint64 {
+		return 0, errors.New("registry entry has the maximum revision")
+	}
+	return revision + 1, nil
+}
+
+// skylinkFromEntryData returns the skylink stored in the data of a registry
+// entry. skynet-js stores skylinks in their raw form, while older versions
+// stored them base64 encoded.
+func skylinkFromEntryData(data []byte) (string, error) {
+	switch len(data) {
+	case base64.RawURLEncoding.DecodedLen(base64SkylinkSize):
+		return base64.RawURLEncoding.EncodeToString(data), nil
+	case base64SkylinkSize:
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("registry entry does not contain a skylink")
+	}
+}
+
+// newRevisionCache creates an empty revisionCache.
+func newRevisionCache() *revisionCache {
+	return &revisionCache{revisions: make(map[string]uint64)}
+}
+
+// get returns the cached revision of an entry. A nil cache contains no
+// revisions.
+func (rc *revisionCache) get(publicKey PublicKey, dataKey string) (uint64, bool) {
+	if rc == nil {
+		return 0, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	revision, cached := rc.revisions[revisionCacheKey(publicKey, dataKey)]
+	return revision, cached
+}
+
+// update caches the revision of an entry unless a higher revision is cached.
+func (rc *revisionCache) update(publicKey PublicKey, dataKey string, revision uint64) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	key := revisionCacheKey(publicKey, dataKey)
+	if cached, exists := rc.revisions[key]; !exists || revision > cached {
+		rc.revisions[key] = revision
+	}
+}
+
+// remove removes the cached revision of an entry.
+func (rc *revisionCache) remove(publicKey PublicKey, dataKey string) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.revisions, revisionCacheKey(publicKey, dataKey))
+}
+
+// revisionCacheKey returns the key of an entry in a revisionCache.
+func revisionCacheKey(publicKey PublicKey, dataKey string) string {
+	return publicKey.String() + "/" + string(hashDataKey(dataKey))
+}
@@ -0,0 +1,445 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+type (
+	// skyfileDecrypter decrypts the data of a skyfile that a portal encrypted
+	// with a skykey, using the raw sectors of the skyfile.
+	skyfileDecrypter struct {
+		sc   *SkynetClient
+		opts Options
+
+		layout skyfileLayout
+		// fanout contains the merkle roots of the pieces of the chunks of
+		// large skyfiles, and payload the data of skyfiles that fit in the
+		// base sector.
+		fanout  []byte
+		payload []byte
+		// fanoutKey is the key the pieces of the fanout are derived from.
+		fanoutKey skykeyData
+	}
+
+	// skyfileDecryptReader reads a range of the decrypted data of a skyfile.
+	skyfileDecryptReader struct {
+		d      *skyfileDecrypter
+		offset uint64
+		end    uint64
+
+		// chunk is the decrypted chunk with the index chunkIndex, which is
+		// the last chunk that was downloaded.
+		chunk      []byte
+		chunkIndex uint64
+	}
+)
+
+const (
+	// skyfileBaseSectorEndpointPath is the endpoint used to fetch the raw base
+	// sector of a skyfile.
+	skyfileBaseSectorEndpointPath = "/skynet/basesector"
+	// skyfileRootEndpointPath is the endpoint used to fetch raw data of a
+	// sector by its merkle root.
+	skyfileRootEndpointPath = "/skynet/root"
+
+	// skyfileHashSize is the size of the merkle roots in the fanout.
+	skyfileHashSize = 32
+)
+
+var (
+	// ErrSkykeyMismatch is returned when decrypting a skyfile with a skykey
+	// other than the one it was encrypted with.
+	ErrSkykeyMismatch = errors.New("skyfile was not encrypted with the skykey")
+
+	// cipherTypeXChaCha20 is the cipher type of skyfiles encrypted with a
+	// skykey.
+	cipherTypeXChaCha20 = [8]byte{0, 0, 0, 0, 0, 0, 0, 4}
+
+	// Specifiers used by portals to derive the keys of encrypted skyfiles.
+	baseSectorNonceDerivation     = [16]byte{'B', 'a', 's', 'e', 'S', 'e', 'c', 't', 'o', 'r', 'N', 'o', 'n', 'c', 'e'}
+	fanoutNonceDerivation         = [16]byte{'F', 'a', 'n', 'o', 'u', 't', 'N', 'o', 'n', 'c', 'e'}
+	skyfileEncryptionIDDerivation = [16]byte{'S', 'F', 'E', 'n', 'c', 'I', 'D', 'D', 'e', 'r', 'i', 'v', 'P', 'a', 't', 'h'}
+	skyfileEncryptionIDSpecifier  = [16]byte{'S', 'k', 'y', 'f', 'i', 'l', 'e', 'E', 'n', 'c', 'I', 'D'}
+)
+
+// localSkykey returns the skykey used to decrypt a download locally, or nil if
+// the portal should decrypt it. That is opts.Skykey, or the skykey named
+// opts.SkykeyName if it is in the keystore.
+func (sc *SkynetClient) localSkykey(opts DownloadOptions) (*Skykey, error) {
+	if opts.Skykey != nil {
+		return opts.Skykey, nil
+	}
+	keystore := opts.Keystore
+	if keystore == nil {
+		keystore = sc.Options.Keystore
+	}
+	if keystore == nil || opts.SkykeyName == "" {
+		return nil, nil
+	}
+	sk, err := keystore.Skykey(opts.SkykeyName)
+	if errors.Contains(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "could not get skykey from keystore")
+	}
+	return &sk, nil
+}
+
+// downloadSkykeyEncrypted downloads the raw sectors of the skyfile at skylink,
+// which a portal encrypted with the given skykey, and decrypts them locally.
+// If skylink contains a path, only the file at that path is returned.
+func (sc *SkynetClient) downloadSkykeyEncrypted(skylink string, sk Skykey, opts DownloadOptions) (DownloadResult, error) {
+	key, err := decodeSkykey(sk.Skykey)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	link, path := skylink, ""
+	if i := strings.IndexByte(skylink, '/'); i >= 0 {
+		link, path = skylink[:i], strings.Trim(skylink[i:], "/")
+	}
+
+	d := &skyfileDecrypter{sc: sc, opts: opts.Options}
+	metadata, err := d.downloadBaseSector(link, key)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	// Find the requested range and its metadata.
+	offset, length := uint64(0), d.layout.filesize
+	filename := metadata.Filename
+	contentType := "application/octet-stream"
+	if path != "" {
+		subfile, ok := metadata.Subfiles[path]
+		if !ok {
+			return DownloadResult{}, errors.AddContext(ErrNotFound, fmt.Sprintf("skyfile does not contain %v", path))
+		}
+		offset, length = subfile.Offset, subfile.Len
+		filename, contentType = subfile.Filename, subfile.ContentType
+	} else if len(metadata.Subfiles) == 1 {
+		for _, subfile := range metadata.Subfiles {
+			contentType = subfile.ContentType
+		}
+	}
+	if offset+length < offset || offset+length > d.layout.filesize {
+		return DownloadResult{}, errors.AddContext(ErrDecryptionFailed, "metadata does not match the size of the skyfile")
+	}
+
+	tracker := newProgressTracker(opts.Progress)
+	tracker.setTotal(int64(length))
+	tracker.setPhase(ProgressPhaseReceiving)
+	body := &skyfileDecryptReader{d: d, offset: offset, end: offset + length}
+	return DownloadResult{
+		Body:          newProgressReadCloser(body, tracker, ProgressPhaseDone),
+		ContentType:   contentType,
+		ContentLength: int64(length),
+		Filename:      filename,
+		Skylink:       URISkynetPrefix + link,
+		PortalURL:     sc.PortalURL,
+	}, nil
+}
+
+// downloadBaseSector downloads and decrypts the base sector of the skyfile at
+// skylink and returns its metadata.
+func (d *skyfileDecrypter) downloadBaseSector(skylink string, key skykeyData) (skyfileMetadata, error) {
+	opts := d.opts
+	opts.EndpointPath = skyfileBaseSectorEndpointPath
+	resp, err := d.sc.executeRequest(
+		requestOptions{
+			Options:   opts,
+			method:    "GET",
+			reqBody:   &bytes.Buffer{},
+			extraPath: skylink,
+		},
+	)
+	if err != nil {
+		return skyfileMetadata{}, errors.AddContext(err, "could not download base sector")
+	}
+	baseSector, err := ioutil.ReadAll(io.LimitReader(resp.Body, sectorSize))
+	err = errors.Compose(err, resp.Body.Close())
+	if err != nil {
+		return skyfileMetadata{}, errors.AddContext(err, "could not read base sector")
+	}
+
+	encryptedLayout, err := decodeSkyfileLayout(baseSector)
+	if err != nil {
+		return skyfileMetadata{}, err
+	}
+	if encryptedLayout.cipherType != cipherTypeXChaCha20 {
+		return skyfileMetadata{}, errors.AddContext(ErrNotEncrypted, "skyfile is not encrypted with a skykey")
+	}
+
+	// The key data of the layout is not encrypted. It contains the ID of the
+	// skykey or an encrypted identifier, followed by the nonce of the
+	// file-specific key.
+	id := encryptedLayout.keyData[:skykeyIDSize]
+	nonce := encryptedLayout.keyData[skykeyIDSize : skykeyIDSize+skykeyEntropySize-skykeyKeySize]
+	fileKey := key.withNonce(nonce)
+	if !fileKey.matchesSkyfileID(key, id) {
+		return skyfileMetadata{}, ErrSkykeyMismatch
+	}
+
+	fileKey.deriveSubkey(baseSectorNonceDerivation).xorKeyStream(baseSector, 0)
+	d.layout, err = decodeSkyfileLayout(baseSector)
+	if err != nil {
+		return skyfileMetadata{}, err
+	}
+	d.layout.version = encryptedLayout.version
+	d.layout.cipherType = encryptedLayout.cipherType
+	d.layout.keyData = encryptedLayout.keyData
+	d.fanoutKey = fileKey.deriveSubkey(fanoutNonceDerivation)
+	if d.layout.version != skyfileVersion {
+		return skyfileMetadata{}, fmt.Errorf("unsupported skyfile version %v", d.layout.version)
+	}
+
+	// Split the rest of the base sector into the fanout, the metadata and
+	// the payload.
+	rest := baseSector[skyfileLayoutSize:]
+	if d.layout.fanoutSize > uint64(len(rest)) || d.layout.metadataSize > uint64(len(rest))-d.layout.fanoutSize {
+		return skyfileMetadata{}, errors.AddContext(ErrDecryptionFailed, "base sector is too short for its layout")
+	}
+	d.fanout = rest[:d.layout.fanoutSize]
+	rawMetadata := rest[d.layout.fanoutSize : d.layout.fanoutSize+d.layout.metadataSize]
+	rest = rest[d.layout.fanoutSize+d.layout.metadataSize:]
+	var metadata skyfileMetadata
+	err = json.Unmarshal(rawMetadata, &metadata)
+	if err != nil {
+		return skyfileMetadata{}, errors.Compose(ErrDecryptionFailed, errors.AddContext(err, "could not unmarshal metadata"))
+	}
+
+	if d.layout.fanoutSize == 0 {
+		if d.layout.filesize > uint64(len(rest)) {
+			return skyfileMetadata{}, errors.AddContext(ErrDecryptionFailed, "base sector is too short for the file")
+		}
+		d.payload = rest[:d.layout.filesize]
+		return metadata, nil
+	}
+	err = validateFanoutPieces(int(d.layout.fanoutDataPieces), int(d.layout.fanoutParityPieces))
+	if err != nil {
+		return skyfileMetadata{}, err
+	}
+	numChunks := uint64(len(d.fanout)) / d.chunkRootsSize()
+	if uint64(len(d.fanout))%d.chunkRootsSize() != 0 || numChunks*d.chunkSize() < d.layout.filesize {
+		return skyfileMetadata{}, errors.AddContext(ErrDecryptionFailed, "fanout does not match the size of the skyfile")
+	}
+	return metadata, nil
+}
+
+// chunkSize returns the size of the data of a chunk of the fanout.
+func (d *skyfileDecrypter) chunkSize() uint64 {
+	return uint64(d.layout.fanoutDataPieces) * sectorSize
+}
+
+// chunkRootsSize returns the size of the merkle roots of a chunk in the
+// fanout. Unlike for unencrypted skyfiles, the roots of all pieces are stored
+// even for 1-of-N erasure coding, since the pieces are encrypted with
+// different keys.
+func (d *skyfileDecrypter) chunkRootsSize() uint64 {
+	return skyfileHashSize * (uint64(d.layout.fanoutDataPieces) + uint64(d.layout.fanoutParityPieces))
+}
+
+// downloadChunk downloads and decrypts the chunk with the given index. The
+// data pieces are downloaded concurrently, and parity pieces are only
+// downloaded if data pieces fail. Only the part of the last chunk that
+// contains data of the file is downloaded.
+func (d *skyfileDecrypter) downloadChunk(index uint64) ([]byte, error) {
+	dataPieces := int(d.layout.fanoutDataPieces)
+	numPieces := dataPieces + int(d.layout.fanoutParityPieces)
+	rowSize := uint64(dataPieces) * segmentSize
+	pieceLen := uint64(sectorSize)
+	if remaining := d.layout.filesize - index*d.chunkSize(); remaining < d.chunkSize() {
+		pieceLen = (remaining + rowSize - 1) / rowSize * segmentSize
+	}
+	roots := d.fanout[index*d.chunkRootsSize():]
+
+	shards := make([][]byte, numPieces)
+	errs := make([]error, numPieces)
+	var wg sync.WaitGroup
+	for i := 0; i < dataPieces; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shards[i], errs[i] = d.downloadPiece(index, uint64(i), roots[i*skyfileHashSize:(i+1)*skyfileHashSize], pieceLen)
+		}(i)
+	}
+	wg.Wait()
+	available := 0
+	for i := 0; i < dataPieces; i++ {
+		if errs[i] == nil {
+			available++
+		}
+	}
+	for i := dataPieces; i < numPieces && available < dataPieces; i++ {
+		shards[i], errs[i] = d.downloadPiece(index, uint64(i), roots[i*skyfileHashSize:(i+1)*skyfileHashSize], pieceLen)
+		if errs[i] == nil {
+			available++
+		}
+	}
+	if available < dataPieces {
+		return nil, errors.AddContext(errors.Compose(errs...), fmt.Sprintf("could not download enough pieces of chunk %v", index))
+	}
+
+	// Recover missing data pieces from the parity pieces.
+	for i := 0; i < dataPieces; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		enc, err := reedsolomon.New(dataPieces, numPieces-dataPieces)
+		if err != nil {
+			return nil, errors.AddContext(err, "could not create erasure coder")
+		}
+		err = enc.ReconstructData(shards)
+		if err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("could not recover chunk %v", index))
+		}
+		break
+	}
+
+	// Every row of the chunk consists of one segment of every data piece.
+	chunk := make([]byte, uint64(dataPieces)*pieceLen)
+	for row := uint64(0); row < pieceLen/segmentSize; row++ {
+		for i := 0; i < dataPieces; i++ {
+			copy(chunk[row*rowSize+uint64(i)*segmentSize:], shards[i][row*segmentSize:(row+1)*segmentSize])
+		}
+	}
+	return chunk, nil
+}
+
+// downloadPiece downloads and decrypts the first length bytes of a piece of a
+// chunk.
+func (d *skyfileDecrypter) downloadPiece(chunkIndex, pieceIndex uint64, root []byte, length uint64) ([]byte, error) {
+	values := url.Values{}
+	values.Set("root", hex.EncodeToString(root))
+	values.Set("offset", "0")
+	values.Set("length", strconv.FormatUint(length, 10))
+	opts := d.opts
+	opts.EndpointPath = skyfileRootEndpointPath
+	resp, err := d.sc.executeRequest(
+		requestOptions{
+			Options: opts,
+			method:  "GET",
+			reqBody: &bytes.Buffer{},
+			query:   values,
+		},
+	)
+	if err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("could not download piece %v of chunk %v", pieceIndex, chunkIndex))
+	}
+	piece := make([]byte, length)
+	_, err = io.ReadFull(resp.Body, piece)
+	err = errors.Compose(err, resp.Body.Close())
+	if err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("could not read piece %v of chunk %v", pieceIndex, chunkIndex))
+	}
+	d.fanoutKey.pieceKey(chunkIndex, pieceIndex).xorKeyStream(piece, 0)
+	return piece, nil
+}
+
+// Read implements io.Reader.
+func (r *skyfileDecryptReader) Read(p []byte) (int, error) {
+	if r.offset >= r.end {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > r.end-r.offset {
+		p = p[:r.end-r.offset]
+	}
+	if r.d.layout.fanoutSize == 0 {
+		n := copy(p, r.d.payload[r.offset:])
+		r.offset += uint64(n)
+		return n, nil
+	}
+
+	chunkSize := r.d.chunkSize()
+	index := r.offset / chunkSize
+	if r.chunk == nil || r.chunkIndex != index {
+		chunk, err := r.d.downloadChunk(index)
+		if err != nil {
+			return 0, err
+		}
+		r.chunk, r.chunkIndex = chunk, index
+	}
+	n := copy(p, r.chunk[r.offset-index*chunkSize:])
+	r.offset += uint64(n)
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (r *skyfileDecryptReader) Close() error {
+	r.chunk = nil
+	return nil
+}
+
+// withNonce returns a key with the same key data but the given nonce.
+func (data skykeyData) withNonce(nonce []byte) skykeyData {
+	entropy := make([]byte, skykeyEntropySize)
+	copy(entropy, data.entropy[:skykeyKeySize])
+	copy(entropy[skykeyKeySize:], nonce)
+	return skykeyData{name: data.name, skykeyType: data.skykeyType, entropy: entropy}
+}
+
+// deriveSubkey derives a subkey whose nonce is the hash of the key's nonce and
+// the given derivation.
+func (data skykeyData) deriveSubkey(derivation [16]byte) skykeyData {
+	var b bytes.Buffer
+	nonce := data.entropy[skykeyKeySize:]
+	_ = binary.Write(&b, binary.LittleEndian, uint64(len(nonce)))
+	b.Write(nonce)
+	_ = binary.Write(&b, binary.LittleEndian, uint64(len(derivation)))
+	b.Write(derivation[:])
+	h := blake2b.Sum256(b.Bytes())
+	return data.withNonce(h[:])
+}
+
+// pieceKey derives the key of a piece of the fanout from the fanout key.
+func (data skykeyData) pieceKey(chunkIndex, pieceIndex uint64) skykeyData {
+	var b bytes.Buffer
+	b.Write(data.entropy[skykeyKeySize:])
+	_ = binary.Write(&b, binary.LittleEndian, chunkIndex)
+	_ = binary.Write(&b, binary.LittleEndian, pieceIndex)
+	h := blake2b.Sum256(b.Bytes())
+	return data.withNonce(h[:])
+}
+
+// matchesSkyfileID checks whether the ID in the layout of a skyfile belongs to
+// the skykey, where data is the file-specific key derived from it. Skyfiles
+// encrypted with public-id skykeys contain the ID of the skykey. Skyfiles
+// encrypted with private-id skykeys contain an identifier encrypted with a key
+// derived from the file-specific key instead.
+func (data skykeyData) matchesSkyfileID(skykey skykeyData, id []byte) bool {
+	switch skykey.skykeyType {
+	case SkykeyTypePublicID:
+		return bytes.Equal(skykey.rawID(), id)
+	case SkykeyTypePrivateID:
+		plaintext := append([]byte(nil), id...)
+		data.deriveSubkey(skyfileEncryptionIDDerivation).xorKeyStream(plaintext, 0)
+		return bytes.Equal(plaintext, skyfileEncryptionIDSpecifier[:])
+	default:
+		return false
+	}
+}
+
+// xorKeyStream encrypts or decrypts b in place with XChaCha20, starting at the
+// given block of the key stream.
+func (data skykeyData) xorKeyStream(b []byte, block uint32) {
+	c, err := chacha20.NewUnauthenticatedCipher(data.entropy[:skykeyKeySize], data.entropy[skykeyKeySize:])
+	if err != nil {
+		panic(err) // only possible for invalid key or nonce sizes
+	}
+	c.SetCounter(block)
+	c.XORKeyStream(b, b)
+}
@@ -0,0 +1,148 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// TestSkyfileKeyDerivation tests the derivation of the keys of skyfiles
+// encrypted with a skykey against keys derived by skyd.
+func TestSkyfileKeyDerivation(t *testing.T) {
+	entropy := make([]byte, skykeyEntropySize)
+	for i := range entropy {
+		entropy[i] = byte(i)
+	}
+	fileNonce := bytes.Repeat([]byte{0xAA}, skykeyEntropySize-skykeyKeySize)
+	key := skykeyData{name: "mykey", skykeyType: SkykeyTypePrivateID, entropy: entropy}
+	fileKey := key.withNonce(fileNonce)
+
+	tests := []struct {
+		key   skykeyData
+		nonce string
+	}{
+		{fileKey.deriveSubkey(baseSectorNonceDerivation), "c3554914b2814311ff9f1a431df6bb597d3bb5fa70e82b60"},
+		{fileKey.deriveSubkey(fanoutNonceDerivation), "d0909c4fb0fa9ec66c60fbcd3030854853770b6ad6e64621"},
+		{fileKey.deriveSubkey(fanoutNonceDerivation).pieceKey(3, 5), "db5be6309ac499f5f3f5e584f4315770f0f90b99e5d9a1d8"},
+	}
+	for _, test := range tests {
+		if !bytes.Equal(test.key.entropy[:skykeyKeySize], entropy[:skykeyKeySize]) {
+			t.Fatal("derived key doesn't match the skykey")
+		}
+		if nonce := hex.EncodeToString(test.key.entropy[skykeyKeySize:]); nonce != test.nonce {
+			t.Fatalf("expected nonce %v, got %v", test.nonce, nonce)
+		}
+	}
+
+	// Check the encrypted identifier of private-id skyfiles.
+	id, _ := hex.DecodeString("7758dad415df07cd6e872c5d85eef258")
+	if !fileKey.matchesSkyfileID(key, id) {
+		t.Fatal("expected private-id identifier to match")
+	}
+	if fileKey.matchesSkyfileID(key, key.rawID()) {
+		t.Fatal("expected skykey ID not to match a private-id skyfile")
+	}
+	key.skykeyType = SkykeyTypePublicID
+	if !fileKey.matchesSkyfileID(key, key.rawID()) {
+		t.Fatal("expected skykey ID to match a public-id skyfile")
+	}
+}
+
+// TestSkyfileDecryptFanout tests decrypting a skyfile with a fanout of two
+// chunks, where a data piece of the first chunk is missing.
+func TestSkyfileDecryptFanout(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	const dataPieces, parityPieces = 2, 1
+	data := make([]byte, 2*dataPieces*sectorSize+1000)
+	_, _ = rand.Read(data)
+	sk, err := GenerateSkykey("", SkykeyTypePrivateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeSkykey(sk.Skykey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fanoutKey := key.deriveSubkey(fanoutNonceDerivation)
+
+	// Erasure code and encrypt the chunks the way portals do, using the
+	// index of each piece as its root.
+	enc, err := reedsolomon.New(dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieces := make(map[string][]byte)
+	var fanout []byte
+	for chunkIndex := 0; chunkIndex*dataPieces*sectorSize < len(data); chunkIndex++ {
+		shards := make([][]byte, dataPieces+parityPieces)
+		for i := range shards {
+			shards[i] = make([]byte, sectorSize)
+		}
+		chunk := data[chunkIndex*dataPieces*sectorSize:]
+		for row := 0; row < sectorSize/segmentSize; row++ {
+			for i := 0; i < dataPieces; i++ {
+				offset := (row*dataPieces + i) * segmentSize
+				if offset < len(chunk) {
+					copy(shards[i][row*segmentSize:(row+1)*segmentSize], chunk[offset:])
+				}
+			}
+		}
+		err = enc.Encode(shards)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, shard := range shards {
+			root := make([]byte, skyfileHashSize)
+			root[0], root[1] = byte(chunkIndex), byte(i)
+			fanout = append(fanout, root...)
+			if chunkIndex == 0 && i == 0 {
+				continue
+			}
+			fanoutKey.pieceKey(uint64(chunkIndex), uint64(i)).xorKeyStream(shard, 0)
+			pieces[hex.EncodeToString(root)] = shard
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		piece, ok := pieces[r.URL.Query().Get("root")]
+		length, err := strconv.Atoi(r.URL.Query().Get("length"))
+		if r.URL.Path != skyfileRootEndpointPath || !ok || err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(piece[:length])
+	}))
+	defer server.Close()
+
+	client := NewCustom(server.URL, Options{})
+	d := &skyfileDecrypter{
+		sc:   &client,
+		opts: DefaultOptions(""),
+		layout: skyfileLayout{
+			filesize:           uint64(len(data)),
+			fanoutSize:         uint64(len(fanout)),
+			fanoutDataPieces:   dataPieces,
+			fanoutParityPieces: parityPieces,
+		},
+		fanout:    fanout,
+		fanoutKey: fanoutKey,
+	}
+	offset := uint64(sectorSize + 100)
+	r := &skyfileDecryptReader{d: d, offset: offset, end: uint64(len(data))}
+	decrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data[offset:]) {
+		t.Fatal("decrypted data doesn't match")
+	}
+}
@@ -0,0 +1,211 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// SkykeyType is the type of a skykey, which decides how the IDs of files
+	// encrypted with it are derived.
+	SkykeyType byte
+
+	// skykeyData is the decoded data of a skykey.
+	skykeyData struct {
+		name       string
+		skykeyType SkykeyType
+		// entropy contains the key followed by the nonce.
+		entropy []byte
+	}
+)
+
+const (
+	// SkykeyTypeInvalid is the zero value of SkykeyType.
+	SkykeyTypeInvalid SkykeyType = 0x00
+	// SkykeyTypePublicID is a skykey whose files contain its ID, so anyone
+	// can tell which files were encrypted with the same skykey.
+	SkykeyTypePublicID SkykeyType = 0x01
+	// SkykeyTypePrivateID is a skykey whose files only reveal their skykey
+	// to those who have it.
+	SkykeyTypePrivateID SkykeyType = 0x02
+
+	// MaxSkykeyNameLength is the maximum length of the name of a skykey.
+	MaxSkykeyNameLength = 128
+
+	// skykeyScheme is the URI scheme of encoded skykeys.
+	skykeyScheme = "skykey"
+	// skykeyIDSize is the size of skykey IDs.
+	skykeyIDSize = 16
+	// skykeyKeySize is the size of the key in the entropy of a skykey, which
+	// is followed by an XChaCha20 nonce.
+	skykeyKeySize = 32
+	// skykeyEntropySize is the size of the entropy of a skykey.
+	skykeyEntropySize = skykeyKeySize + 24
+)
+
+var (
+	// ErrInvalidSkykey is returned for skykeys that can't be decoded or whose
+	// fields don't match.
+	ErrInvalidSkykey = errors.New("invalid skykey")
+
+	// skykeySpecifier is the specifier hashed into skykey IDs.
+	skykeySpecifier = [16]byte{'S', 'k', 'y', 'k', 'e', 'y'}
+)
+
+// String returns the name of the type as used by portals, such as
+// "private-id".
+func (t SkykeyType) String() string {
+	switch t {
+	case SkykeyTypePublicID:
+		return "public-id"
+	case SkykeyTypePrivateID:
+		return "private-id"
+	default:
+		return "invalid"
+	}
+}
+
+// ParseSkykeyType parses the name of a skykey type as used by portals.
+func ParseSkykeyType(s string) (SkykeyType, error) {
+	switch s {
+	case "public-id":
+		return SkykeyTypePublicID, nil
+	case "private-id":
+		return SkykeyTypePrivateID, nil
+	default:
+		return SkykeyTypeInvalid, fmt.Errorf("invalid skykey type %q", s)
+	}
+}
+
+// GenerateSkykey generates a new skykey with the given name and type without
+// contacting a portal. The skykey can be added to a portal with AddSkykey.
+func GenerateSkykey(name string, skykeyType SkykeyType) (Skykey, error) {
+	if skykeyType != SkykeyTypePublicID && skykeyType != SkykeyTypePrivateID {
+		return Skykey{}, fmt.Errorf("invalid skykey type %v", skykeyType)
+	}
+	if len(name) > MaxSkykeyNameLength {
+		return Skykey{}, fmt.Errorf("skykey name is longer than %v bytes", MaxSkykeyNameLength)
+	}
+	data := skykeyData{
+		name:       name,
+		skykeyType: skykeyType,
+		entropy:    make([]byte, skykeyEntropySize),
+	}
+	_, err := rand.Read(data.entropy)
+	if err != nil {
+		return Skykey{}, errors.AddContext(err, "could not generate entropy")
+	}
+	return data.skykey(), nil
+}
+
+// ParseSkykey decodes the given skykey, such as "skykey:AQAB...?name=key",
+// without contacting a portal. The name, type and ID of the returned Skykey
+// are derived from it.
+func ParseSkykey(skykey string) (Skykey, error) {
+	data, err := decodeSkykey(skykey)
+	if err != nil {
+		return Skykey{}, err
+	}
+	return data.skykey(), nil
+}
+
+// Validate checks that the encoded skykey can be decoded and that its name,
+// type and ID match the other fields.
+func (sk Skykey) Validate() error {
+	data, err := decodeSkykey(sk.Skykey)
+	if err != nil {
+		return err
+	}
+	if sk.Name != data.name {
+		return errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("name %q doesn't match skykey name %q", sk.Name, data.name))
+	}
+	if sk.Type != data.skykeyType.String() {
+		return errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("type %q doesn't match skykey type %q", sk.Type, data.skykeyType))
+	}
+	if id := data.id(); sk.ID != id {
+		return errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("ID %v doesn't match skykey ID %v", sk.ID, id))
+	}
+	return nil
+}
+
+// decodeSkykey decodes the given skykey.
+func decodeSkykey(skykey string) (skykeyData, error) {
+	u, err := url.Parse(skykey)
+	if err != nil {
+		return skykeyData{}, errors.Compose(ErrInvalidSkykey, err)
+	}
+	var encoded string
+	switch u.Scheme {
+	case skykeyScheme:
+		encoded = u.Opaque
+	case "":
+		encoded = u.Path
+	default:
+		return skykeyData{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("unknown scheme %q", u.Scheme))
+	}
+
+	data := skykeyData{name: u.Query().Get("name")}
+	if len(data.name) > MaxSkykeyNameLength {
+		return skykeyData{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("name is longer than %v bytes", MaxSkykeyNameLength))
+	}
+	b, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return skykeyData{}, errors.AddContext(ErrInvalidSkykey, "could not decode base64")
+	}
+	if len(b) == 0 {
+		return skykeyData{}, errors.AddContext(ErrInvalidSkykey, "skykey is empty")
+	}
+	data.skykeyType = SkykeyType(b[0])
+	if data.skykeyType != SkykeyTypePublicID && data.skykeyType != SkykeyTypePrivateID {
+		return skykeyData{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("unsupported type %v", b[0]))
+	}
+	if len(b) != 1+skykeyEntropySize {
+		return skykeyData{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("expected %v bytes of entropy, got %v", skykeyEntropySize, len(b)-1))
+	}
+	data.entropy = b[1:]
+	return data, nil
+}
+
+// skykey returns the Skykey for the data.
+func (data skykeyData) skykey() Skykey {
+	return Skykey{
+		Skykey: data.encode(),
+		Name:   data.name,
+		ID:     data.id(),
+		Type:   data.skykeyType.String(),
+	}
+}
+
+// encode encodes the skykey the same way portals do.
+func (data skykeyData) encode() string {
+	b := append([]byte{byte(data.skykeyType)}, data.entropy...)
+	encoded := skykeyScheme + ":" + base64.URLEncoding.EncodeToString(b)
+	if data.name != "" {
+		encoded += "?name=" + data.name
+	}
+	return encoded
+}
+
+// id returns the base64 encoded ID of the skykey.
+func (data skykeyData) id() string {
+	return base64.URLEncoding.EncodeToString(data.rawID())
+}
+
+// rawID returns the ID of the skykey, which is derived from its type and key,
+// but not its nonce.
+func (data skykeyData) rawID() []byte {
+	var b bytes.Buffer
+	b.Write(skykeySpecifier[:])
+	_ = binary.Write(&b, binary.LittleEndian, uint64(data.skykeyType))
+	_ = binary.Write(&b, binary.LittleEndian, uint64(skykeyKeySize))
+	b.Write(data.entropy[:skykeyKeySize])
+	h := blake2b.Sum256(b.Bytes())
+	return h[:skykeyIDSize]
+}
@@ -0,0 +1,142 @@
+package skynet
+
+import (
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestParseSkykey tests parsing skykeys against skykeys encoded by skyd.
+func TestParseSkykey(t *testing.T) {
+	tests := []Skykey{
+		{
+			Skykey: "skykey:AQABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4fICEiIyQlJicoKSorLC0uLzAxMjM0NTY3?name=mykey",
+			Name:   "mykey",
+			ID:     "z_vT_DQu0KZ-yK3zSb0HUA==",
+			Type:   "public-id",
+		},
+		{
+			Skykey: "skykey:AgABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4fICEiIyQlJicoKSorLC0uLzAxMjM0NTY3?name=mykey",
+			Name:   "mykey",
+			ID:     "Ao0DNdCtbe1z105Qrv1Phw==",
+			Type:   "private-id",
+		},
+	}
+	for _, expected := range tests {
+		sk, err := ParseSkykey(expected.Skykey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sk != expected {
+			t.Fatalf("expected %+v, got %+v", expected, sk)
+		}
+		if err := sk.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The scheme is optional.
+		sk, err = ParseSkykey(strings.TrimPrefix(expected.Skykey, "skykey:"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sk != expected {
+			t.Fatalf("expected %+v, got %+v", expected, sk)
+		}
+	}
+
+	valid := tests[0].Skykey
+	invalid := []string{
+		"",
+		"skykey:",
+		"skykey:!" + valid[8:],
+		"skykey:AA" + valid[9:],
+		"skykey:/w" + valid[9:],
+		"skykey:AQAB",
+		"https:" + valid[7:],
+		valid[:strings.IndexByte(valid, '?')] + "?name=" + strings.Repeat("a", MaxSkykeyNameLength+1),
+		valid[:strings.IndexByte(valid, '?')-4] + "?name=mykey",
+	}
+	for _, s := range invalid {
+		_, err := ParseSkykey(s)
+		if !errors.Contains(err, ErrInvalidSkykey) {
+			t.Fatalf("%q: expected %v, got %v", s, ErrInvalidSkykey, err)
+		}
+	}
+}
+
+// TestSkykeyValidate tests that Validate detects mismatched fields.
+func TestSkykeyValidate(t *testing.T) {
+	sk, err := ParseSkykey("skykey:AQABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4fICEiIyQlJicoKSorLC0uLzAxMjM0NTY3?name=mykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongName := sk
+	wrongName.Name = "otherkey"
+	wrongType := sk
+	wrongType.Type = SkykeyTypePrivateID.String()
+	wrongID := sk
+	wrongID.ID = "Ao0DNdCtbe1z105Qrv1Phw=="
+	for _, invalid := range []Skykey{wrongName, wrongType, wrongID} {
+		err := invalid.Validate()
+		if !errors.Contains(err, ErrInvalidSkykey) {
+			t.Fatalf("%+v: expected %v, got %v", invalid, ErrInvalidSkykey, err)
+		}
+	}
+}
+
+// TestGenerateSkykey tests generating skykeys.
+func TestGenerateSkykey(t *testing.T) {
+	for _, skykeyType := range []SkykeyType{SkykeyTypePublicID, SkykeyTypePrivateID} {
+		sk, err := GenerateSkykey("testkey", skykeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sk.Name != "testkey" || sk.Type != skykeyType.String() {
+			t.Fatalf("unexpected skykey %+v", sk)
+		}
+		if err := sk.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSkykey(sk.Skykey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != sk {
+			t.Fatalf("expected %+v, got %+v", sk, parsed)
+		}
+		other, err := GenerateSkykey("testkey", skykeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.ID == sk.ID {
+			t.Fatal("expected different skykeys")
+		}
+	}
+
+	_, err := GenerateSkykey("testkey", SkykeyTypeInvalid)
+	if err == nil {
+		t.Fatal("expected error for invalid type")
+	}
+	_, err = GenerateSkykey(strings.Repeat("a", MaxSkykeyNameLength+1), SkykeyTypePublicID)
+	if err == nil {
+		t.Fatal("expected error for long name")
+	}
+}
+
+// TestParseSkykeyType tests converting skykey types to and from strings.
+func TestParseSkykeyType(t *testing.T) {
+	for _, skykeyType := range []SkykeyType{SkykeyTypePublicID, SkykeyTypePrivateID} {
+		parsed, err := ParseSkykeyType(skykeyType.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != skykeyType {
+			t.Fatalf("expected %v, got %v", skykeyType, parsed)
+		}
+	}
+	if _, err := ParseSkykeyType("invalid"); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,389 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// skyfileLayout is the layout at the start of the base sector of a
+	// skyfile, which describes the rest of the skyfile.
+	skyfileLayout struct {
+		version            uint8
+		filesize           uint64
+		metadataSize       uint64
+		fanoutSize         uint64
+		fanoutDataPieces   uint8
+		fanoutParityPieces uint8
+		cipherType         [8]byte
+		keyData            [64]byte
+	}
+
+	// skyfileMetadata is the metadata of a skyfile as it is stored in the
+	// base sector. The fields and their order must match the portal's, since
+	// the JSON encoding is part of the data the skylink is computed from.
+	skyfileMetadata struct {
+		Filename           string                            `json:"filename"`
+		Length             uint64                            `json:"length"`
+		Mode               os.FileMode                       `json:"mode,omitempty"`
+		Subfiles           map[string]skyfileSubfileMetadata `json:"subfiles,omitempty"`
+		DefaultPath        string                            `json:"defaultpath,omitempty"`
+		DisableDefaultPath bool                              `json:"disabledefaultpath,omitempty"`
+		TryFiles           []string                          `json:"tryfiles,omitempty"`
+		ErrorPages         map[int]string                    `json:"errorpages,omitempty"`
+	}
+
+	// skyfileSubfileMetadata is the metadata of a single file of a skyfile.
+	skyfileSubfileMetadata struct {
+		FileMode    os.FileMode `json:"mode,omitempty"`
+		Filename    string      `json:"filename,omitempty"`
+		ContentType string      `json:"contenttype,omitempty"`
+		Offset      uint64      `json:"offset,omitempty"`
+		Len         uint64      `json:"len,omitempty"`
+	}
+
+	// skyfileReader reads the data of the parts of an upload one after the
+	// other, and sets their offsets and lengths in the metadata.
+	skyfileReader struct {
+		parts    []uploadPart
+		metadata *skyfileMetadata
+		// partLen is the number of bytes read from the first part.
+		partLen int64
+	}
+)
+
+const (
+	// sectorSize is the size of a sector on the Sia network.
+	sectorSize = 1 << 22
+	// segmentSize is the size of the leaves of the merkle tree of a sector.
+	segmentSize = 64
+
+	// skyfileLayoutSize is the size of the encoded skyfileLayout.
+	skyfileLayoutSize = 99
+	// skyfileVersion is the version of the skyfiles created by portals.
+	skyfileVersion = 1
+
+	// base64SkylinkSize is the length of base64 encoded skylinks.
+	base64SkylinkSize = 46
+)
+
+var (
+	// ErrSkyfileTooLarge is returned when computing the skylink of an upload
+	// whose fanout and metadata don't fit in a single base sector.
+	ErrSkyfileTooLarge = errors.New("upload does not fit in a base sector")
+
+	// cipherTypePlain is the cipher type of unencrypted skyfiles.
+	cipherTypePlain = [8]byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+	// defaultTryFiles are the try files portals use if neither try files
+	// nor a default path are set.
+	defaultTryFiles = []string{"index.html"}
+)
+
+// ComputeSkylink computes the skylink that UploadEntries returns for the given
+// files and options, without contacting a portal. Uploads that fit in a single
+// base sector of 4 MiB together with their metadata are stored in it directly.
+// Larger uploads are erasure coded with FanoutDataPieces and
+// FanoutParityPieces, which must match the portal's settings and are ignored
+// for uploads that fit in the base sector. Encrypted
+// uploads are not supported.
+func ComputeSkylink(entries []UploadEntry, opts UploadOptions) (string, error) {
+	if opts.SkykeyName != "" || opts.SkykeyID != "" || opts.EncryptionKey != nil {
+		return "", errors.New("skylinks of encrypted uploads can't be computed")
+	}
+	req, err := newUploadRequest(entries, opts)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := req.skyfileMetadata()
+	if err != nil {
+		return "", errors.AddContext(err, "could not create metadata")
+	}
+
+	// Like the portal, read up to a sector to find out whether the upload
+	// fits in the base sector.
+	r := &skyfileReader{parts: req.parts, metadata: &metadata}
+	data := make([]byte, sectorSize)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	data = data[:n]
+	var metadataBytes []byte
+	if err != nil {
+		metadataBytes, err = json.Marshal(metadata)
+		if err != nil {
+			return "", errors.AddContext(err, "could not marshal metadata")
+		}
+	}
+	layout := skyfileLayout{
+		version:    skyfileVersion,
+		filesize:   uint64(len(data)),
+		cipherType: cipherTypePlain,
+	}
+	var fanout []byte
+	if metadataBytes == nil || skyfileLayoutSize+len(metadataBytes)+len(data) > sectorSize {
+		// Large uploads are referenced by a fanout instead. The erasure
+		// coding parameters are only needed for them.
+		err = validateFanoutPieces(opts.FanoutDataPieces, opts.FanoutParityPieces)
+		if err != nil {
+			return "", err
+		}
+		fanout, layout.filesize, err = computeFanout(io.MultiReader(bytes.NewReader(data), r), opts.FanoutDataPieces, opts.FanoutParityPieces)
+		if err != nil {
+			return "", errors.AddContext(err, "could not compute fanout")
+		}
+		data = nil
+		layout.fanoutSize = uint64(len(fanout))
+		layout.fanoutDataPieces = uint8(opts.FanoutDataPieces)
+		layout.fanoutParityPieces = uint8(opts.FanoutParityPieces)
+		metadataBytes, err = json.Marshal(metadata)
+		if err != nil {
+			return "", errors.AddContext(err, "could not marshal metadata")
+		}
+		if skyfileLayoutSize+len(fanout)+len(metadataBytes) > sectorSize {
+			return "", ErrSkyfileTooLarge
+		}
+	}
+	layout.metadataSize = uint64(len(metadataBytes))
+
+	// Build the base sector.
+	baseSector := make([]byte, sectorSize)
+	n = copy(baseSector, layout.encode())
+	n += copy(baseSector[n:], fanout)
+	n += copy(baseSector[n:], metadataBytes)
+	n += copy(baseSector[n:], data)
+
+	skylink, err := newSkylinkV1(sectorMerkleRoot(baseSector), 0, uint64(n))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s", URISkynetPrefix, skylink), nil
+}
+
+// ComputeFileSkylink computes the skylink that UploadFile returns for the
+// given file and options, without contacting a portal.
+func ComputeFileSkylink(path string, opts UploadOptions) (skylink string, err error) {
+	entry, file, err := fileEntry(path, opts)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errors.Extend(err, file.Close())
+	}()
+	return ComputeSkylink([]UploadEntry{entry}, opts)
+}
+
+// ComputeDirectorySkylink computes the skylink that UploadDirectory returns
+// for the given directory and options, without contacting a portal.
+func ComputeDirectorySkylink(path string, opts UploadOptions) (skylink string, err error) {
+	entries, err := directoryEntries(path, &opts)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errors.Compose(err, closeLazyFiles(entries))
+	}()
+	return ComputeSkylink(entries, opts)
+}
+
+// skyfileMetadata returns the metadata the portal creates for the request,
+// except for the lengths and offsets of the files, which are set while reading
+// them with a skyfileReader.
+func (ur *uploadRequest) skyfileMetadata() (skyfileMetadata, error) {
+	metadata := skyfileMetadata{
+		Filename:           ur.query.Get("filename"),
+		Subfiles:           make(map[string]skyfileSubfileMetadata, len(ur.parts)),
+		DefaultPath:        ur.query.Get("defaultpath"),
+		DisableDefaultPath: ur.query.Get("disabledefaultpath") == "true",
+	}
+	if metadata.Filename == "" && len(ur.parts) == 1 {
+		metadata.Filename = ur.parts[0].filename
+	}
+	if err := validateSkyfilePath(metadata.Filename); err != nil {
+		return skyfileMetadata{}, errors.AddContext(err, "invalid filename "+metadata.Filename)
+	}
+
+	for _, part := range ur.parts {
+		if err := validateSkyfilePath(part.filename); err != nil {
+			return skyfileMetadata{}, errors.AddContext(err, "invalid filename "+part.filename)
+		}
+		var mode os.FileMode
+		if modeStr := part.header.Get("Mode"); modeStr != "" {
+			if _, err := fmt.Sscanf(modeStr, "%o", &mode); err != nil {
+				return skyfileMetadata{}, errors.AddContext(err, "invalid mode "+modeStr)
+			}
+		}
+		metadata.Subfiles[part.filename] = skyfileSubfileMetadata{
+			FileMode:    mode,
+			Filename:    part.filename,
+			ContentType: part.header.Get("Content-Type"),
+		}
+	}
+
+	// The portal uses the default try files only if the parameter is not
+	// set at all.
+	if _, isSet := ur.query["tryfiles"]; isSet {
+		if err := json.Unmarshal([]byte(ur.query.Get("tryfiles")), &metadata.TryFiles); err != nil {
+			return skyfileMetadata{}, errors.AddContext(err, "invalid tryfiles")
+		}
+	} else if metadata.DefaultPath == "" && !metadata.DisableDefaultPath {
+		metadata.TryFiles = defaultTryFiles
+	}
+	if errorPages := ur.query.Get("errorpages"); errorPages != "" {
+		if err := json.Unmarshal([]byte(errorPages), &metadata.ErrorPages); err != nil {
+			return skyfileMetadata{}, errors.AddContext(err, "invalid errorpages")
+		}
+	}
+	return metadata, nil
+}
+
+// Read implements io.Reader.
+func (sr *skyfileReader) Read(p []byte) (int, error) {
+	for len(sr.parts) > 0 {
+		part := sr.parts[0]
+		n, err := part.reader.Read(p)
+		sr.partLen += int64(n)
+		if err == io.EOF {
+			err = sr.finishPart()
+		}
+		if err != nil {
+			return n, errors.AddContext(err, fmt.Sprintf("could not read file %v", part.filename))
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// finishPart sets the offset and length of the current part in the metadata
+// and moves on to the next part.
+func (sr *skyfileReader) finishPart() error {
+	part := sr.parts[0]
+	if part.size >= 0 && sr.partLen != part.size {
+		return fmt.Errorf("size of file %v changed: expected %v bytes, got %v", part.filename, part.size, sr.partLen)
+	}
+	subfile := sr.metadata.Subfiles[part.filename]
+	subfile.Offset = sr.metadata.Length
+	subfile.Len = uint64(sr.partLen)
+	sr.metadata.Subfiles[part.filename] = subfile
+	sr.metadata.Length += uint64(sr.partLen)
+
+	sr.parts = sr.parts[1:]
+	sr.partLen = 0
+	return nil
+}
+
+// encode encodes the layout in the format used in the base sector.
+func (sl skyfileLayout) encode() []byte {
+	b := make([]byte, skyfileLayoutSize)
+	b[0] = sl.version
+	binary.LittleEndian.PutUint64(b[1:], sl.filesize)
+	binary.LittleEndian.PutUint64(b[9:], sl.metadataSize)
+	binary.LittleEndian.PutUint64(b[17:], sl.fanoutSize)
+	b[25] = sl.fanoutDataPieces
+	b[26] = sl.fanoutParityPieces
+	copy(b[27:], sl.cipherType[:])
+	copy(b[35:], sl.keyData[:])
+	return b
+}
+
+// decodeSkyfileLayout decodes the layout at the start of a base sector.
+func decodeSkyfileLayout(b []byte) (skyfileLayout, error) {
+	if len(b) < skyfileLayoutSize {
+		return skyfileLayout{}, fmt.Errorf("base sector of %v bytes is too short for a layout", len(b))
+	}
+	var sl skyfileLayout
+	sl.version = b[0]
+	sl.filesize = binary.LittleEndian.Uint64(b[1:])
+	sl.metadataSize = binary.LittleEndian.Uint64(b[9:])
+	sl.fanoutSize = binary.LittleEndian.Uint64(b[17:])
+	sl.fanoutDataPieces = b[25]
+	sl.fanoutParityPieces = b[26]
+	copy(sl.cipherType[:], b[27:])
+	copy(sl.keyData[:], b[35:])
+	return sl, nil
+}
+
+// newSkylinkV1 returns the base64 encoded v1 skylink for the given range of
+// the sector with the given merkle root. The fetch size is rounded up to the
+// next size the skylink format supports.
+func newSkylinkV1(root [32]byte, offset, fetchSize uint64) (string, error) {
+	if offset+fetchSize > sectorSize {
+		return "", errors.New("offset plus fetch size cannot exceed the size of a sector")
+	}
+
+	// The offset alignment is 512 KiB for fetch sizes of 2 MiB and above,
+	// and halves with the fetch size down to 4 KiB.
+	minFetchSize := uint64(1 << 21)
+	offsetAlign := uint64(1 << 19)
+	for fetchSize <= minFetchSize && offsetAlign > 1<<12 {
+		offsetAlign >>= 1
+		minFetchSize >>= 1
+	}
+	if offset&(offsetAlign-1) != 0 {
+		return "", errors.New("offset is not aligned correctly")
+	}
+	bitwiseOffset := uint16(offset / offsetAlign)
+
+	// The fetch size alignment is half the offset alignment, but at least
+	// 4 KiB. Every mode but the first starts at 8 times the alignment.
+	fetchSizeAlign := uint64(1 << 12)
+	if offsetAlign > 1<<13 {
+		fetchSizeAlign = offsetAlign >> 1
+	}
+	if offsetAlign > 1<<12 {
+		fetchSize -= fetchSizeAlign * 8
+	}
+	// The fetch size is encoded in the range [1, 8] times the alignment, so
+	// round down and decrement exact multiples.
+	if fetchSize != 0 && fetchSize%fetchSizeAlign == 0 {
+		fetchSize--
+	}
+	bitwiseFetchSize := uint16(fetchSize / fetchSizeAlign)
+
+	// The bitfield consists of the offset, the fetch size, a 0 bit
+	// terminating the mode bits, one 1 bit per mode and 2 version bits.
+	bitfield := bitwiseOffset<<3 + bitwiseFetchSize
+	bitfield <<= 1
+	for align := uint64(1 << 12); align < offsetAlign; align <<= 1 {
+		bitfield = bitfield<<1 + 1
+	}
+	bitfield <<= 2
+
+	raw := make([]byte, 34)
+	binary.LittleEndian.PutUint16(raw, bitfield)
+	copy(raw[2:], root[:])
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// validateSkyfilePath validates a filename of a skyfile the way portals do.
+func validateSkyfilePath(path string) error {
+	switch {
+	case path == "":
+		return errors.New("path cannot be empty")
+	case strings.HasPrefix(path, "/"):
+		return errors.New("path cannot begin with /")
+	case !utf8.ValidString(path):
+		return errors.New("path is not valid utf8")
+	}
+	for _, elem := range strings.Split(path, "/") {
+		switch elem {
+		case "":
+			return errors.New("path cannot contain empty elements")
+		case ".", "..":
+			return errors.New("path cannot contain . or .. elements")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package skynet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestNewSkylinkV1 tests encoding v1 skylinks. The expected skylinks are
+// regression vectors.
+func TestNewSkylinkV1(t *testing.T) {
+	root := [32]byte{1, 2, 3}
+	tests := []struct {
+		offset, fetchSize uint64
+		skylink           string
+	}{
+		{0, 1, "AAABAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{0, 4096, "AAABAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{0, 4097, "CAABAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{4096, 100, "QAABAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{0, 40000, "FAABAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{32768, 70000, "DAIBAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{0, 2097152, "_A4BAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{524288, 3145728, "_C0BAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{0, 4194304, "_B0BAgMAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+	}
+	for _, test := range tests {
+		skylink, err := newSkylinkV1(root, test.offset, test.fetchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skylink != test.skylink {
+			t.Errorf("offset %v, fetch size %v: expected %v, got %v", test.offset, test.fetchSize, test.skylink, skylink)
+		}
+	}
+
+	// Invalid ranges should fail.
+	if _, err := newSkylinkV1(root, 100, 100); err == nil {
+		t.Error("expected unaligned offset to fail")
+	}
+	if _, err := newSkylinkV1(root, 1<<21, 1<<22); err == nil {
+		t.Error("expected range exceeding the sector to fail")
+	}
+}
+
+// TestComputeSkylink tests computing the skylinks of small uploads. The
+// expected skylinks are regression vectors.
+func TestComputeSkylink(t *testing.T) {
+	opts := DefaultUploadOptions
+
+	skylink, err := ComputeFileSkylink("testdata/file1.txt", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sia://AABmKLMAwK-rYr0ocnOz0pMIDpxk0ulrUuph0HUsTJ6HnA"; skylink != expected {
+		t.Fatalf("expected file skylink %v, got %v", expected, skylink)
+	}
+
+	skylink, err = ComputeDirectorySkylink("testdata", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sia://AAA6T4HaT_fHBStaeqPWXOhXv65IkCXB3J4qApWH5dHzCw"; skylink != expected {
+		t.Fatalf("expected directory skylink %v, got %v", expected, skylink)
+	}
+
+	webAppOpts := opts
+	webAppOpts.TryFiles = []string{"index.html", "/file1.txt"}
+	webAppOpts.ErrorPages = map[int]string{404: "/file2.txt", 500: "/dir1/file3.txt"}
+	skylink, err = ComputeDirectorySkylink("testdata", webAppOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sia://AAAReMmVQVhldk1vDspxxWdQZx6wF_39BY7U6ntLULukdw"; skylink != expected {
+		t.Fatalf("expected web app skylink %v, got %v", expected, skylink)
+	}
+
+	entriesOpts := opts
+	entriesOpts.DisableDefaultPath = true
+	entriesOpts.CustomDirname = "my <site> & co"
+	// The content type of b/run.sh is the one it was detected with when the
+	// vector was recorded.
+	newEntries := func(scriptContentType string) []UploadEntry {
+		return []UploadEntry{
+			{Filename: "b/run.sh", Reader: strings.NewReader("#!/bin/sh\necho hi\n"), ContentType: scriptContentType, Mode: 0755},
+			{Filename: "a.bin", Reader: strings.NewReader(strings.Repeat("x", 300000)), ContentType: "application/x-custom"},
+			{Filename: "empty", Reader: strings.NewReader(""), ContentType: "text/plain"},
+		}
+	}
+	skylink, err = ComputeSkylink(newEntries("application/octet-stream"), entriesOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sia://vABcIPiASDbCMW658PbUZOOgLCaAyYZcUz2vp_A6qW0jFw"; skylink != expected {
+		t.Fatalf("expected entries skylink %v, got %v", expected, skylink)
+	}
+	// The detected content type is part of the metadata, so it changes the
+	// skylink.
+	detected, err := ComputeSkylink(newEntries(""), entriesOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detected == skylink {
+		t.Fatal("expected detected content type to change the skylink")
+	}
+
+	// The largest file that fits in the base sector.
+	entries := []UploadEntry{{
+		Filename:    "data",
+		Reader:      bytes.NewReader(bytes.Repeat([]byte("y"), 4193904)),
+		ContentType: "application/octet-stream",
+	}}
+	skylink, err = ComputeSkylink(entries, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sia://_B1QMY_24dVWGVvHBRhmB6rNl7A35MOyC2GCRnrvdFAlzQ"; skylink != expected {
+		t.Fatalf("expected large skylink %v, got %v", expected, skylink)
+	}
+
+	// Encrypted uploads and invalid filenames are not supported.
+	encryptedOpts := opts
+	encryptedOpts.SkykeyName = "key"
+	if _, err := ComputeFileSkylink("testdata/file1.txt", encryptedOpts); err == nil {
+		t.Fatal("expected encrypted upload to fail")
+	}
+	entries = []UploadEntry{{Filename: "../file", Reader: strings.NewReader("data")}}
+	if _, err := ComputeSkylink(entries, opts); err == nil {
+		t.Fatal("expected invalid filename to fail")
+	}
+
+	// Small uploads don't need erasure coding parameters.
+	entries = []UploadEntry{{Filename: "data", Reader: strings.NewReader("data")}}
+	if _, err := ComputeSkylink(entries, UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestComputeSkylinkFanout tests computing the skylinks of uploads that don't
+// fit in a base sector. The expected skylinks are regression vectors for the
+// given erasure coding parameters.
+func TestComputeSkylinkFanout(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	tests := []struct {
+		size                     int
+		dataPieces, parityPieces int
+		skylink                  string
+	}{
+		{4194304, 10, 20, "sia://AAA48qJri4NLJSt6whsme18z_X3l81qMCn7AhRcw95fseQ"},
+		{5242897, 10, 20, "sia://AAB3CkGyIvyd6PTAmBmgBpYtru3AeTUAzT3mvjxUx5vRjg"},
+		{9437187, 1, 4, "sia://AAB0OjD0VGD1g37UxeXd7xaPWalApbdjO-93AF2MyZNkPA"},
+		{9437187, 3, 2, "sia://AABYyqDQN7fxD_vc4AqX30y4j4-8brGVnOosbNS2U7WK4g"},
+	}
+	for _, test := range tests {
+		data := make([]byte, test.size)
+		for i := range data {
+			data[i] = byte(i*7 + i/1000)
+		}
+		entries := []UploadEntry{
+			{Filename: "x.txt", Reader: strings.NewReader("hello"), ContentType: "text/plain"},
+			{Filename: "data", Reader: bytes.NewReader(data), ContentType: "application/octet-stream"},
+		}
+		opts := DefaultUploadOptions
+		opts.CustomDirname = "big"
+		opts.FanoutDataPieces = test.dataPieces
+		opts.FanoutParityPieces = test.parityPieces
+		skylink, err := ComputeSkylink(entries, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skylink != test.skylink {
+			t.Errorf("size %v, %v-of-%v: expected %v, got %v", test.size, test.dataPieces, test.dataPieces+test.parityPieces, test.skylink, skylink)
+		}
+	}
+
+	// Invalid erasure coding parameters should fail.
+	opts := DefaultUploadOptions
+	opts.FanoutDataPieces = 0
+	entries := []UploadEntry{{Filename: "data", Reader: bytes.NewReader(make([]byte, sectorSize))}}
+	if _, err := ComputeSkylink(entries, opts); err == nil {
+		t.Fatal("expected 0 data pieces to fail")
+	}
+}
+
+// TestComputeSkylinkTooLarge tests that computing the skylink of an upload
+// whose metadata does not fit in a base sector fails.
+func TestComputeSkylinkTooLarge(t *testing.T) {
+	opts := DefaultUploadOptions
+	opts.CustomDirname = strings.Repeat("a", sectorSize)
+	opts.FanoutDataPieces = 1
+	entries := []UploadEntry{{Filename: "data", Reader: strings.NewReader("data")}}
+	_, err := ComputeSkylink(entries, opts)
+	if !errors.Contains(err, ErrSkyfileTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrSkyfileTooLarge, err)
+	}
+}
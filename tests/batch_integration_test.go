@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestUploadBatch tests uploading files and readers concurrently.
+func TestUploadBatch(t *testing.T) {
+	const workers = 3
+
+	// The server returns the filename as the skylink and fails uploads of
+	// files named "fail". The first requests are blocked until as many
+	// requests as there are workers have arrived, so that all workers are
+	// active at the same time.
+	var mu sync.Mutex
+	var arrived, active, maxActive int
+	barrier := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrived++
+		if arrived == workers {
+			close(barrier)
+		}
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+		select {
+		case <-barrier:
+		case <-time.After(10 * time.Second):
+		}
+
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filename := header.Filename
+		if filename == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": "upload failed"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": filename})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	items := []skynet.BatchItem{{Path: srcFile}}
+	for _, name := range []string{"a", "b", "fail", "c", "d", "e", "f"} {
+		items = append(items, skynet.BatchItem{UploadEntry: skynet.UploadEntry{Filename: name, Reader: strings.NewReader(name)}})
+	}
+
+	// Upload the items in best-effort mode.
+	opts := skynet.DefaultBatchUploadOptions
+	opts.Workers = workers
+	var reported []skynet.BatchResult
+	opts.OnResult = func(result skynet.BatchResult) {
+		reported = append(reported, result)
+	}
+	summary, err := client.UploadBatch(items, opts)
+	if !errors.Contains(err, skynet.ErrBatchFailed) {
+		t.Fatalf("expected %v, got %v", skynet.ErrBatchFailed, err)
+	}
+	if summary.Succeeded != len(items)-1 || summary.Failed != 1 || summary.Skipped != 0 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if len(reported) != len(items) {
+		t.Fatalf("expected %v reported results, got %v", len(items), len(reported))
+	}
+	if maxActive != workers {
+		t.Fatalf("expected %v concurrent uploads, got %v", workers, maxActive)
+	}
+	for i, result := range summary.Results {
+		if result.Index != i {
+			t.Fatalf("expected index %v, got %v", i, result.Index)
+		}
+		name := items[i].Filename
+		if i == 0 {
+			name = "file1.txt"
+		}
+		if name == "fail" {
+			if result.Err == nil || result.Skylink != "" {
+				t.Fatalf("expected item %v to fail, got %+v", i, result)
+			}
+			continue
+		}
+		if result.Err != nil || result.Skylink != skynet.URISkynetPrefix+name {
+			t.Fatalf("unexpected result of item %v: %+v", i, result)
+		}
+	}
+
+	// Upload the items one at a time in fail-fast mode. The items after the
+	// failure should be skipped.
+	for _, item := range items[1:] {
+		item.Reader.(*strings.Reader).Reset(item.Filename)
+	}
+	opts.Workers = 1
+	opts.FailFast = true
+	reported = nil
+	summary, err = client.UploadBatch(items, opts)
+	if !errors.Contains(err, skynet.ErrBatchFailed) {
+		t.Fatalf("expected %v, got %v", skynet.ErrBatchFailed, err)
+	}
+	if summary.Succeeded != 3 || summary.Failed != 1 || summary.Skipped != 4 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if len(reported) != len(items) {
+		t.Fatalf("expected %v reported results, got %v", len(items), len(reported))
+	}
+	for _, result := range summary.Results[4:] {
+		if result.Err != skynet.ErrBatchItemSkipped {
+			t.Fatalf("expected %v, got %v", skynet.ErrBatchItemSkipped, result.Err)
+		}
+	}
+
+	// Upload files by path.
+	summary, err = client.UploadFiles([]string{srcFile, "../testdata/file2.txt"}, skynet.DefaultBatchUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Succeeded != 2 || summary.Results[1].Skylink != skynet.URISkynetPrefix+"file2.txt" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+}
@@ -2,12 +2,23 @@ package tests
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -140,3 +151,466 @@ func TestDownloadFileSkykey(t *testing.T) {
 		t.Fatal("test finished with pending mocks")
 	}
 }
+
+// TestDownloadProgress tests that progress is reported during a download.
+func TestDownloadProgress(t *testing.T) {
+	defer gock.Off()
+
+	const skylink = "XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"
+
+	var updates []skynet.Progress
+	opts := skynet.DefaultDownloadOptions
+	opts.Progress = func(p skynet.Progress) {
+		updates = append(updates, p)
+	}
+	urlpath := strings.TrimRight(opts.EndpointPath, "/") + "/" + skylink
+	gock.New(skynet.DefaultPortalURL()).
+		Get(urlpath).
+		Reply(200).
+		SetHeader("Content-Length", "5").
+		BodyString("test\n")
+
+	body, err := client.Download(skylink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updates) == 0 {
+		t.Fatal("expected progress updates")
+	}
+	last := updates[len(updates)-1]
+	if last.Phase != skynet.ProgressPhaseDone {
+		t.Fatalf("expected last phase to be %v, got %v", skynet.ProgressPhaseDone, last.Phase)
+	}
+	if last.BytesTransferred != int64(len(data)) {
+		t.Fatalf("expected %v bytes transferred, got %v", len(data), last.BytesTransferred)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestDownloadWithResult tests that the response headers of a download are
+// returned.
+func TestDownloadWithResult(t *testing.T) {
+	defer gock.Off()
+
+	const skylink = "XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"
+	const resolvedSkylink = "AACogzrAimYPG42tDOKhS3lXZD8YvlF8Q8R17afe95iV2Q"
+
+	opts := skynet.DefaultDownloadOptions
+	urlpath := strings.TrimRight(opts.EndpointPath, "/") + "/" + skylink
+	gock.New(skynet.DefaultPortalURL()).
+		Get(urlpath).
+		Reply(200).
+		SetHeader("Content-Type", "text/plain; charset=utf-8").
+		SetHeader("Content-Length", "5").
+		SetHeader("Content-Disposition", `inline; filename="file1.txt"`).
+		SetHeader("Skynet-Skylink", resolvedSkylink).
+		SetHeader("ETag", `"abc"`).
+		BodyString("test\n")
+
+	result, err := client.DownloadWithResult(skylink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "test\n" {
+		t.Fatalf("unexpected body %q", data)
+	}
+	expected := skynet.DownloadResult{
+		Body:          result.Body,
+		ContentType:   "text/plain; charset=utf-8",
+		ContentLength: 5,
+		Filename:      "file1.txt",
+		Skylink:       skynet.URISkynetPrefix + resolvedSkylink,
+		ETag:          `"abc"`,
+		PortalURL:     skynet.DefaultPortalURL(),
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestDownloadEncrypted tests that files encrypted with an EncryptionKey are
+// uploaded encrypted and decrypted on download.
+func TestDownloadEncrypted(t *testing.T) {
+	// The server stores the uploaded file and serves it for any skylink.
+	var stored []byte
+	var storedContentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			storedContentType = header.Header.Get("Content-Type")
+			stored, _ = ioutil.ReadAll(file)
+			_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(stored)))
+		_, _ = w.Write(stored)
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	key, err := skynet.NewPassphraseEncryptionKey("passphrase", skynet.KDFParams{Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("secret data\n"), 20000)
+
+	uploadOpts := skynet.DefaultUploadOptions
+	uploadOpts.EncryptionKey = key
+	_, err = client.Upload(skynet.UploadData{"secret.txt": bytes.NewReader(data)}, uploadOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("secret")) || int64(len(stored)) != skynet.EncryptedSize(int64(len(data))) {
+		t.Fatal("expected portal to receive only the encrypted data")
+	}
+	if storedContentType != "application/octet-stream" {
+		t.Fatalf("expected content type to be hidden, got %v", storedContentType)
+	}
+
+	// Download the data with and without decrypting it.
+	downloadOpts := skynet.DefaultDownloadOptions
+	downloadOpts.EncryptionKey = key
+	result, err := client.DownloadWithResult(skylink, downloadOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) || result.ContentLength != int64(len(data)) {
+		t.Fatalf("expected decrypted data of length %v, got %v bytes and length %v", len(data), len(downloaded), result.ContentLength)
+	}
+	body, err := client.Download(skylink, skynet.DefaultDownloadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err = ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, stored) {
+		t.Fatal("expected encrypted data without a key")
+	}
+
+	// Downloading unencrypted data with a key fails.
+	stored = data
+	_, err = client.Download(skylink, downloadOpts)
+	if !errors.Contains(err, skynet.ErrNotEncrypted) {
+		t.Fatalf("expected %v, got %v", skynet.ErrNotEncrypted, err)
+	}
+}
+
+// TestOpenEncrypted tests random access to an encrypted file with Range
+// requests.
+func TestOpenEncrypted(t *testing.T) {
+	const chunkSize = 1 << 16
+
+	key, err := skynet.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 5*chunkSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	r, err := skynet.NewEncryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server serves the encrypted data with support for Range requests
+	// and counts the bytes it sends.
+	var sent int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counter := &countingResponseWriter{ResponseWriter: w}
+		http.ServeContent(counter, r, "", time.Time{}, bytes.NewReader(encrypted))
+		sent += counter.n
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	opts := skynet.DefaultDownloadOptions
+	opts.EncryptionKey = key
+	file, err := client.OpenEncrypted(skylink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Size() != int64(len(data)) {
+		t.Fatalf("expected size %v, got %v", len(data), file.Size())
+	}
+
+	// Read ranges within a chunk, across chunks and at the end of the file.
+	tests := []struct {
+		offset int64
+		length int
+	}{
+		{0, 10},
+		{chunkSize - 5, 10},
+		{2*chunkSize + 3, 2 * chunkSize},
+		{int64(len(data)) - 50, 50},
+	}
+	for _, test := range tests {
+		sent = 0
+		buf := make([]byte, test.length)
+		n, err := file.ReadAt(buf, test.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], data[test.offset:test.offset+int64(test.length)]) {
+			t.Fatalf("unexpected data at offset %v", test.offset)
+		}
+		chunks := (test.offset+int64(test.length)-1)/chunkSize - test.offset/chunkSize + 1
+		if sent > chunks*(chunkSize+16) {
+			t.Fatalf("expected at most %v chunks to be downloaded, got %v bytes", chunks, sent)
+		}
+	}
+
+	// Reading past the end returns io.EOF.
+	buf := make([]byte, 100)
+	n, err := file.ReadAt(buf, int64(len(data))-10)
+	if err != io.EOF || n != 10 {
+		t.Fatalf("expected 10 bytes and EOF, got %v and %v", n, err)
+	}
+
+	// Seek and read the rest of the file in small pieces.
+	offset, err := file.Seek(-chunkSize-10, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent = 0
+	rest, err := ioutil.ReadAll(io.LimitReader(struct{ io.Reader }{file}, 1<<30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, data[offset:]) {
+		t.Fatal("unexpected data after seeking")
+	}
+	if sent > 3*(chunkSize+16) {
+		t.Fatalf("expected every chunk to be downloaded once, got %v bytes", sent)
+	}
+}
+
+// TestOpenEncryptedWithoutRange tests random access to an encrypted file on a
+// portal that ignores Range requests and doesn't send a Content-Length.
+func TestOpenEncryptedWithoutRange(t *testing.T) {
+	key, err := skynet.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3<<16+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	r, err := skynet.NewEncryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server sends the whole file in chunked encoding and counts the
+	// requests.
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(encrypted[:100])
+		w.(http.Flusher).Flush()
+		w.Write(encrypted[100:])
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	opts := skynet.DefaultDownloadOptions
+	opts.EncryptionKey = key
+	file, err := client.OpenEncrypted(skylink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Size() != int64(len(data)) {
+		t.Fatalf("expected size %v, got %v", len(data), file.Size())
+	}
+
+	// The file is only downloaded once.
+	for _, offset := range []int64{int64(len(data)) - 50, 10, 1 << 16} {
+		buf := make([]byte, 50)
+		n, err := file.ReadAt(buf, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], data[offset:offset+50]) {
+			t.Fatalf("unexpected data at offset %v", offset)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 request, got %v", requests)
+	}
+}
+
+// countingResponseWriter is an http.ResponseWriter that counts the bytes of
+// the body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+// Write implements io.Writer.
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// TestDownloadSkykeyEncrypted tests decrypting skyfiles that a portal
+// encrypted with a skykey locally. The base sectors were encrypted by skyd.
+func TestDownloadSkykeyEncrypted(t *testing.T) {
+	const data = "hello from an encrypted skyfile"
+	const layoutPrefix = "AcpceimVXxOMTBdN3F9qPNFYX6AgdOHclVHnAAAAAAAAAA"
+	const rest = "qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqq0XFlX2IpVet0rNTBs7g5gAVRkPJKtxQSD+XsssIIuQVrJMvSAhy5iZ9ln2X7tdxwbRpqGftzHobXPAsoOMqucvvKArKMtspxoOrgvH9fMP2rx1l7fheWpH/cN1RtJgvAsqkNXjFAqGC0LrZabZkoBOPTTywdTOaYtLXBi2pYQqWvbTU/i5EfUEKJd+/NvyyJr9rGTd1MFOMpgAmmsF5tQut5l76k6h1sd3wwhugBnHOzLXstz9moWn9ck6a0oSWD"
+	tests := []struct {
+		skykey     string
+		baseSector string
+	}{
+		{
+			skykey:     "skykey:AQABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4fICEiIyQlJicoKSorLC0uLzAxMjM0NTY3?name=mykey",
+			baseSector: layoutPrefix + "TP+9P8NC7Qpn7IrfNJvQdQ" + rest,
+		},
+		{
+			skykey:     "skykey:AgABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4fICEiIyQlJicoKSorLC0uLzAxMjM0NTY3?name=mykey",
+			baseSector: layoutPrefix + "R3WNrUFd8HzW6HLF2F7vJY" + rest,
+		},
+	}
+
+	for _, test := range tests {
+		baseSector, err := base64.StdEncoding.DecodeString(test.baseSector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The portal only serves the raw base sector, so a download that is
+		// decrypted by the portal fails.
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/skynet/basesector/"+skylink || r.URL.Query().Get("skykeyname") != "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(baseSector)
+		}))
+		client := skynet.NewCustom(server.URL, skynet.Options{})
+
+		sk, err := skynet.ParseSkykey(test.skykey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := skynet.DefaultDownloadOptions
+		opts.Skykey = &sk
+		for _, link := range []string{skylink, skylink + "/hello.txt"} {
+			result, err := client.DownloadWithResult(link, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			downloaded, err := ioutil.ReadAll(result.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(downloaded) != data || result.ContentLength != int64(len(data)) {
+				t.Fatalf("expected %q, got %q with length %v", data, downloaded, result.ContentLength)
+			}
+			if result.Filename != "hello.txt" || result.ContentType != "text/plain; charset=utf-8" {
+				t.Fatalf("unexpected filename %v or content type %v", result.Filename, result.ContentType)
+			}
+		}
+
+		// Decrypting with a different skykey must fail.
+		other, err := skynet.GenerateSkykey("mykey", skynet.SkykeyTypePrivateID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Skykey = &other
+		_, err = client.DownloadWithResult(skylink, opts)
+		if !errors.Contains(err, skynet.ErrSkykeyMismatch) {
+			t.Fatalf("expected %v, got %v", skynet.ErrSkykeyMismatch, err)
+		}
+		server.Close()
+	}
+}
+
+// TestDownloadSkykeyFromKeystore tests that SkykeyName is resolved against
+// the keystore, and that skykeys not in the keystore are sent to the portal.
+func TestDownloadSkykeyFromKeystore(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.Query().Get("skykeyname"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ks, err := skynet.OpenKeystore(filepath.Join(dir, "keystore"), "passphrase", skynet.KDFParams{Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := skynet.GenerateSkykey("local", skynet.SkykeyTypePrivateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ks.AddSkykey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := skynet.NewCustom(server.URL, skynet.Options{Keystore: ks})
+	opts := skynet.DefaultDownloadOptions
+	for _, name := range []string{"local", "remote"} {
+		opts.SkykeyName = name
+		_, err = client.DownloadWithResult(skylink, opts)
+		if !errors.Contains(err, skynet.ErrNotFound) {
+			t.Fatalf("expected %v, got %v", skynet.ErrNotFound, err)
+		}
+	}
+	expected := []string{"/skynet/basesector/" + skylink + "?", "/" + skylink + "?remote"}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Fatalf("expected requests %v, got %v", expected, requests)
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"testing"
+
+	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestPin tests pinning a skylink.
+func TestPin(t *testing.T) {
+	defer gock.Off()
+
+	const siaPath = "var/skynet/backups/file"
+
+	opts := skynet.DefaultPinOptions
+	opts.SiaPath = "backups/file"
+	opts.Force = true
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath+"/"+skylink).
+		MatchParam("siapath", "backups/file").
+		MatchParam("force", "true").
+		Reply(200).
+		JSON(map[string]string{"siapath": siaPath})
+
+	pinnedPath, err := client.Pin(sialink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinnedPath != siaPath {
+		t.Fatalf("expected siapath %v, got %v", siaPath, pinnedPath)
+	}
+
+	// Pinning a skylink that doesn't exist returns ErrNotFound.
+	gock.New(skynet.DefaultPortalURL()).
+		Post(skynet.DefaultPinOptions.EndpointPath + "/" + skylink).
+		Reply(404).
+		JSON(map[string]string{"message": "failed to pin file to skynet: root not found"})
+
+	_, err = client.Pin(skylink, skynet.DefaultPinOptions)
+	if !errors.Contains(err, skynet.ErrNotFound) {
+		t.Fatalf("expected %v, got %v", skynet.ErrNotFound, err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestUnpin tests unpinning a skylink.
+func TestUnpin(t *testing.T) {
+	defer gock.Off()
+
+	opts := skynet.DefaultUnpinOptions
+	opts.SiaPath = "var/skynet/file"
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath+"/"+skylink).
+		MatchParam("siapath", "var/skynet/file").
+		Reply(204)
+
+	err := client.Unpin(sialink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other error responses don't return ErrNotFound.
+	gock.New(skynet.DefaultPortalURL()).
+		Post(skynet.DefaultUnpinOptions.EndpointPath + "/" + skylink).
+		Reply(400).
+		JSON(map[string]string{"message": "error parsing skylink"})
+
+	err = client.Unpin(skylink, skynet.DefaultUnpinOptions)
+	if !errors.Contains(err, skynet.ErrResponseError) || errors.Contains(err, skynet.ErrNotFound) {
+		t.Fatalf("expected %v without %v, got %v", skynet.ErrResponseError, skynet.ErrNotFound, err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
@@ -0,0 +1,287 @@
+package tests
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
+	"gopkg.in/h2non/gock.v1"
+)
+
+type (
+	// registryServer is a portal that implements the registry the way skyd
+	// does, without checking signatures.
+	registryServer struct {
+		entries map[string]registryServerEntry
+		mu      sync.Mutex
+	}
+
+	// registryServerEntry is an entry in the same format as skyd's GET
+	// response.
+	registryServerEntry struct {
+		Data      string `json:"data"`
+		DataKey   string `json:"datakey"`
+		Revision  uint64 `json:"revision"`
+		Signature string `json:"signature"`
+	}
+
+	// registryServerRequest is a set request in skyd's format.
+	registryServerRequest struct {
+		PublicKey struct {
+			Algorithm string `json:"algorithm"`
+			Key       []byte `json:"key"`
+		} `json:"publickey"`
+		DataKey   string   `json:"datakey"`
+		Revision  uint64   `json:"revision"`
+		Signature [64]byte `json:"signature"`
+		Data      []byte   `json:"data"`
+	}
+)
+
+// newRegistryServer creates a registryServer.
+func newRegistryServer() *registryServer {
+	return &registryServer{entries: make(map[string]registryServerEntry)}
+}
+
+// ServeHTTP implements http.Handler.
+func (rs *registryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if r.URL.Path != "/skynet/registry" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "GET" {
+		entry, exists := rs.entries[r.URL.Query().Get("publickey")+r.URL.Query().Get("datakey")]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": "registry entry not found"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(entry)
+		return
+	}
+
+	var req registryServerRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.PublicKey.Algorithm != "ed25519" || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := "ed25519:" + hex.EncodeToString(req.PublicKey.Key) + req.DataKey
+	if existing, exists := rs.entries[key]; exists && existing.Revision >= req.Revision {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "provided revision number is invalid"})
+		return
+	}
+	rs.entries[key] = registryServerEntry{
+		Data:      hex.EncodeToString(req.Data),
+		DataKey:   req.DataKey,
+		Revision:  req.Revision,
+		Signature: hex.EncodeToString(req.Signature[:]),
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestRegistry tests setting and getting registry entries.
+func TestRegistry(t *testing.T) {
+	rs := newRegistryServer()
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	kp := skynet.KeyPairFromSeed("insecure test seed")
+	_, err := client.GetEntry(kp.PublicKey, "app", skynet.DefaultGetEntryOptions)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+
+	for revision := uint64(0); revision < 2; revision++ {
+		entry := skynet.RegistryEntry{DataKey: "app", Data: []byte{byte(revision)}, Revision: revision}
+		err = client.SetEntry(kp.PrivateKey, entry, skynet.DefaultSetEntryOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := client.GetEntry(kp.PublicKey, "app", skynet.DefaultGetEntryOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.DataKey != "app" || string(got.Data) != string(entry.Data) || got.Revision != revision || len(got.Signature) != 64 {
+			t.Fatalf("unexpected entry %+v", got)
+		}
+	}
+
+	// Entries of other keys are separate.
+	other := skynet.KeyPairFromSeed("another seed")
+	_, err = client.GetEntry(other.PublicKey, "app", skynet.DefaultGetEntryOptions)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+
+	// Entries with an old revision are rejected.
+	err = client.SetEntry(kp.PrivateKey, skynet.RegistryEntry{DataKey: "app", Revision: 1}, skynet.DefaultSetEntryOptions)
+	if !errors.Contains(err, skynet.ErrRegistryRevisionConflict) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryRevisionConflict, err)
+	}
+
+	// Entries with too much data are rejected before anything is sent.
+	data := make([]byte, skynet.MaxRegistryDataSize+1)
+	err = client.SetEntry(kp.PrivateKey, skynet.RegistryEntry{DataKey: "app", Data: data, Revision: 5}, skynet.DefaultSetEntryOptions)
+	if err == nil {
+		t.Fatal("expected error for too much data")
+	}
+
+	// Entries whose data doesn't match the signature are rejected.
+	for key, entry := range rs.entries {
+		entry.Data = hex.EncodeToString([]byte("modified"))
+		rs.entries[key] = entry
+	}
+	_, err = client.GetEntry(kp.PublicKey, "app", skynet.DefaultGetEntryOptions)
+	if !errors.Contains(err, skynet.ErrInvalidRegistrySignature) {
+		t.Fatalf("expected %v, got %v", skynet.ErrInvalidRegistrySignature, err)
+	}
+}
+
+// TestRegistryTimeout tests the errors returned for registry requests that
+// time out.
+func TestRegistryTimeout(t *testing.T) {
+	defer gock.Off()
+
+	kp := skynet.KeyPairFromSeed("insecure test seed")
+	opts := skynet.DefaultGetEntryOptions
+	opts.Timeout = 1500 * time.Millisecond
+	gock.New(skynet.DefaultPortalURL()).
+		Get(opts.EndpointPath).
+		MatchParam("publickey", kp.PublicKey.String()).
+		MatchParam("timeout", "2").
+		Reply(404).
+		JSON(map[string]string{"message": "unable to read from the registry: registry lookup timed out"})
+
+	_, err := client.GetEntry(kp.PublicKey, "app", opts)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+
+	gock.New(skynet.DefaultPortalURL()).
+		Post(skynet.DefaultSetEntryOptions.EndpointPath).
+		Reply(408).
+		JSON(map[string]string{"message": "Unable to update the registry: registry update timed out"})
+
+	err = client.SetEntry(kp.PrivateKey, skynet.RegistryEntry{DataKey: "app"}, skynet.DefaultSetEntryOptions)
+	if !errors.Contains(err, skynet.ErrRegistryTimeout) || !errors.Contains(err, skynet.ErrRequestTimeout) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryTimeout, err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestUpdateEntry tests updating a registry entry from concurrent workers.
+func TestUpdateEntry(t *testing.T) {
+	rs := newRegistryServer()
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+	kp := skynet.KeyPairFromSeed("insecure test seed")
+
+	// Every worker increments the counter in the entry.
+	const numWorkers = 5
+	opts := skynet.DefaultUpdateEntryOptions
+	opts.MaxRetries = 100
+	opts.RetryInterval = time.Millisecond
+	increment := func(old []byte) ([]byte, error) {
+		if len(old) == 0 {
+			return []byte{1}, nil
+		}
+		return []byte{old[0] + 1}, nil
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.UpdateEntry(kp.PrivateKey, "counter", increment, opts)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := client.GetEntry(kp.PublicKey, "counter", skynet.DefaultGetEntryOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Data) != 1 || entry.Data[0] != numWorkers || entry.Revision != numWorkers-1 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	// The updated entry is returned.
+	updated, err := client.UpdateEntry(kp.PrivateKey, "counter", increment, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Data[0] != numWorkers+1 || updated.Revision != numWorkers || len(updated.Signature) != 64 {
+		t.Fatalf("unexpected entry %+v", updated)
+	}
+
+	// Errors of the update function are returned without setting the entry.
+	errUpdate := errors.New("update failed")
+	_, err = client.UpdateEntry(kp.PrivateKey, "counter", func([]byte) ([]byte, error) {
+		return nil, errUpdate
+	}, opts)
+	if !errors.Contains(err, errUpdate) {
+		t.Fatalf("expected %v, got %v", errUpdate, err)
+	}
+}
+
+// TestUpdateEntryConflict tests that UpdateEntry gives up if another writer
+// keeps updating the entry.
+func TestUpdateEntryConflict(t *testing.T) {
+	rs := newRegistryServer()
+	otherServer := httptest.NewServer(rs)
+	defer otherServer.Close()
+	other := skynet.NewCustom(otherServer.URL, skynet.Options{})
+	kp := skynet.KeyPairFromSeed("insecure test seed")
+
+	// Another writer updates the entry right before every update.
+	sets := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			sets++
+			_, err := other.UpdateEntry(kp.PrivateKey, "counter", func(old []byte) ([]byte, error) {
+				return old, nil
+			}, skynet.DefaultUpdateEntryOptions)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		rs.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	opts := skynet.DefaultUpdateEntryOptions
+	opts.MaxRetries = 3
+	opts.RetryInterval = time.Millisecond
+	_, err := client.UpdateEntry(kp.PrivateKey, "counter", func(old []byte) ([]byte, error) {
+		return old, nil
+	}, opts)
+	if !errors.Contains(err, skynet.ErrRegistryUpdateConflict) || !errors.Contains(err, skynet.ErrRegistryRevisionConflict) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryUpdateConflict, err)
+	}
+	if sets != opts.MaxRetries+1 {
+		t.Fatalf("expected %v attempts, got %v", opts.MaxRetries+1, sets)
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	skynet "github.com/NebulousLabs/go-skynet/v2"
+)
+
+// TestShare tests uploading and downloading a file with a share link.
+func TestShare(t *testing.T) {
+	// The server stores the uploaded file and serves it for any skylink.
+	var stored []byte
+	var storedFilename string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			storedFilename = header.Filename
+			stored, _ = ioutil.ReadAll(file)
+			_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+			return
+		}
+		_, _ = w.Write(stored)
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	shareLink, err := client.UploadShareFile(srcFile, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(shareLink, server.URL+"/"+skylink+"#") {
+		t.Fatalf("unexpected share link %v", shareLink)
+	}
+	if storedFilename == "file1.txt" || bytes.Contains(stored, []byte("file1.txt")) {
+		t.Fatal("expected the filename to be hidden from the portal")
+	}
+
+	// Download the shared file.
+	data, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.DownloadShare(shareLink, skynet.DefaultDownloadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatalf("expected %q, got %q", data, downloaded)
+	}
+	if result.Filename != "file1.txt" || result.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected filename %v or content type %v", result.Filename, result.ContentType)
+	}
+
+	// Download the shared file into a directory.
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = client.DownloadShareFile(dir, shareLink, skynet.DefaultDownloadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err = ioutil.ReadFile(filepath.Join(dir, "file1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatalf("expected %q, got %q", data, downloaded)
+	}
+}
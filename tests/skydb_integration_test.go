@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// skydbServer is a portal that implements the registry, uploads of
+	// single files and downloads.
+	skydbServer struct {
+		*registryServer
+		files map[string][]byte
+		// filenames contains the filenames of the uploaded files.
+		filenames []string
+		// requests counts the requests by method and path.
+		requests map[string]int
+		mu       sync.Mutex
+	}
+
+	// testDocument is a JSON document stored in SkyDB.
+	testDocument struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+)
+
+// newSkydbServer creates a skydbServer.
+func newSkydbServer() *skydbServer {
+	return &skydbServer{
+		registryServer: newRegistryServer(),
+		files:          make(map[string][]byte),
+		requests:       make(map[string]int),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (ss *skydbServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ss.mu.Lock()
+	ss.requests[r.Method+" "+r.URL.Path]++
+	ss.mu.Unlock()
+	switch {
+	case r.URL.Path == "/skynet/registry":
+		ss.registryServer.ServeHTTP(w, r)
+	case r.Method == "POST" && r.URL.Path == "/skynet/skyfile":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(file)
+		h := sha256.Sum256(data)
+		skylink := base64.RawURLEncoding.EncodeToString(append([]byte{1, 0}, h[:]...))
+		ss.mu.Lock()
+		ss.files[skylink] = data
+		ss.filenames = append(ss.filenames, header.Filename)
+		ss.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	default:
+		ss.mu.Lock()
+		data, exists := ss.files[strings.TrimPrefix(r.URL.Path, "/")]
+		ss.mu.Unlock()
+		if r.Method != "GET" || !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+// requestCount returns the number of requests with the given method and path.
+func (ss *skydbServer) requestCount(method, path string) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.requests[method+" "+path]
+}
+
+// TestSkyDB tests setting and getting JSON documents in SkyDB.
+func TestSkyDB(t *testing.T) {
+	ss := newSkydbServer()
+	server := httptest.NewServer(ss)
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+	kp := skynet.KeyPairFromSeed("insecure test seed")
+
+	var document testDocument
+	err := client.GetJSON(kp.PublicKey, "app", &document, skynet.DefaultGetJSONOptions)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+
+	// Only the first write reads the revision from the portal.
+	for i := 0; i < 3; i++ {
+		expected := testDocument{Name: "counter", Count: i}
+		err = client.SetJSON(kp.PrivateKey, "app", expected, skynet.DefaultSetJSONOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.GetJSON(kp.PublicKey, "app", &document, skynet.DefaultGetJSONOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if document != expected {
+			t.Fatalf("expected %+v, got %+v", expected, document)
+		}
+		entry, err := client.GetEntry(kp.PublicKey, "app", skynet.DefaultGetEntryOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Revision != uint64(i) || len(entry.Data) != 34 {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+	}
+	if n := ss.requestCount("GET", "/skynet/registry"); n != 1+1+3*2 {
+		t.Fatalf("expected 8 registry reads, got %v", n)
+	}
+
+	// Documents are uploaded under the same filename as with skynet-js, which
+	// is the hex encoded hash of the data key.
+	encodedKey := make([]byte, 8, 8+len("app"))
+	binary.LittleEndian.PutUint64(encodedKey, uint64(len("app")))
+	hash := blake2b.Sum256(append(encodedKey, "app"...))
+	expectedFilename := "dk:" + hex.EncodeToString(hash[:])
+	if filename := ss.filenames[len(ss.filenames)-1]; filename != expectedFilename {
+		t.Fatalf("expected filename %v, got %v", expectedFilename, filename)
+	}
+
+	// Another writer bumps the revision, so the cached revision is outdated.
+	other := skynet.NewCustom(server.URL, skynet.Options{})
+	err = other.SetJSON(kp.PrivateKey, "app", testDocument{Name: "other"}, skynet.DefaultSetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetJSON(kp.PrivateKey, "app", testDocument{Name: "conflict"}, skynet.DefaultSetJSONOptions)
+	if !errors.Contains(err, skynet.ErrRegistryRevisionConflict) {
+		t.Fatalf("expected %v, got %v", skynet.ErrRegistryRevisionConflict, err)
+	}
+	// The next write reads the revision again and succeeds.
+	err = client.SetJSON(kp.PrivateKey, "app", testDocument{Name: "retry"}, skynet.DefaultSetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.GetJSON(kp.PublicKey, "app", &document, skynet.DefaultGetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document.Name != "retry" {
+		t.Fatalf("expected document %q, got %q", "retry", document.Name)
+	}
+}
+
+// TestSkyDBLegacyDocuments tests reading documents that were uploaded without
+// a version, with the skylink stored base64 encoded in the registry entry.
+func TestSkyDBLegacyDocuments(t *testing.T) {
+	ss := newSkydbServer()
+	server := httptest.NewServer(ss)
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+	kp := skynet.KeyPairFromSeed("insecure test seed")
+
+	ss.files[skylink] = []byte(`{"name":"legacy","count":7}`)
+	err := client.SetEntry(kp.PrivateKey, skynet.RegistryEntry{DataKey: "app", Data: []byte(skylink)}, skynet.DefaultSetEntryOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document testDocument
+	err = client.GetJSON(kp.PublicKey, "app", &document, skynet.DefaultGetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document != (testDocument{Name: "legacy", Count: 7}) {
+		t.Fatalf("unexpected document %+v", document)
+	}
+
+	// Entries that don't contain a skylink can't be read.
+	err = client.SetEntry(kp.PrivateKey, skynet.RegistryEntry{DataKey: "app", Data: []byte("data"), Revision: 1}, skynet.DefaultSetEntryOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.GetJSON(kp.PublicKey, "app", &document, skynet.DefaultGetJSONOptions)
+	if err == nil {
+		t.Fatal("expected error for entry without skylink")
+	}
+}
@@ -1,10 +1,16 @@
 package tests
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"testing"
 
 	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -38,15 +44,15 @@ func TestCreateSkykey(t *testing.T) {
 	const skykey = "skykey:BAAAAAAAAABrZXkxAAAAAAAAAAQgAAAAAAAAADiObVg49-0juJ8udAx4qMW-TEHgDxfjA0fjJSNBuJ4a"
 	const name = "testcreateskykey"
 	const id = "pJAPPfWkWXpss3BvMDCJCw=="
-	const skykeyType = "private-id"
+	const skykeyType = skynet.SkykeyTypePrivateID
 
 	opts := skynet.DefaultCreateSkykeyOptions
 	gock.New(skynet.DefaultPortalURL()).
 		Post(opts.EndpointPath).
 		MatchParam("name", name).
-		MatchParam("type", skykeyType).
+		MatchParam("type", skykeyType.String()).
 		Reply(200).
-		JSON(skynet.Skykey{Skykey: skykey, Name: name, ID: id, Type: skykeyType})
+		JSON(skynet.Skykey{Skykey: skykey, Name: name, ID: id, Type: skykeyType.String()})
 
 	fullSkykey, err := client.CreateSkykey(name, skykeyType, opts)
 	if err != nil {
@@ -57,7 +63,7 @@ func TestCreateSkykey(t *testing.T) {
 		Skykey: skykey,
 		Name:   name,
 		ID:     id,
-		Type:   skykeyType,
+		Type:   skykeyType.String(),
 	}
 	if !reflect.DeepEqual(expectedSkykey, fullSkykey) {
 		t.Fatalf("expected skykey %v, got %v", expectedSkykey, fullSkykey)
@@ -164,3 +170,127 @@ func TestGetSkykeys(t *testing.T) {
 		t.Fatal("test finished with pending mocks")
 	}
 }
+
+// TestDeleteSkykey tests deleting skykeys by name and by ID.
+func TestDeleteSkykey(t *testing.T) {
+	defer gock.Off()
+
+	opts := skynet.DefaultDeleteSkykeyOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("name", "testkey").
+		Reply(204)
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("id", "pJAPPfWkWXpss3BvMDCJCw==").
+		Reply(204)
+
+	err := client.DeleteSkykeyByName("testkey", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.DeleteSkykeyByID("pJAPPfWkWXpss3BvMDCJCw==", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestExportImportSkykeys tests exporting skykeys to a file and importing
+// them again.
+func TestExportImportSkykeys(t *testing.T) {
+	defer gock.Off()
+
+	newSkykey := func(name string) skynet.Skykey {
+		sk, err := skynet.GenerateSkykey(name, skynet.SkykeyTypePrivateID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sk
+	}
+	added := newSkykey("added")
+	duplicate := newSkykey("duplicate")
+	nameConflict := newSkykey("nameconflict")
+	idConflict := newSkykey("idconflict")
+	exported := []skynet.Skykey{added, duplicate, nameConflict, idConflict, added}
+
+	// Export the skykeys.
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "skykeys.json")
+	getOpts := skynet.DefaultGetSkykeysOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Get(getOpts.EndpointPath).
+		Reply(200).
+		JSON(map[string][]skynet.Skykey{"skykeys": exported})
+	skykeys, err := client.ExportSkykeys(path, getOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skykeys, exported) {
+		t.Fatalf("expected %v, got %v", exported, skykeys)
+	}
+	if _, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import them into a node that already has some of them or skykeys with
+	// the same name or ID.
+	existingName := newSkykey("nameconflict")
+	existingID := idConflict
+	existingID.Name = "othername"
+	existing := []skynet.Skykey{duplicate, existingName, existingID}
+
+	opts := skynet.DefaultImportSkykeysOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Get(opts.GetSkykeysOptions.EndpointPath).
+		Reply(200).
+		JSON(map[string][]skynet.Skykey{"skykeys": existing})
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.AddSkykeyOptions.EndpointPath).
+		MatchParam("skykey", regexp.QuoteMeta(added.Skykey)).
+		Reply(204)
+
+	result, err := client.ImportSkykeys(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := skynet.ImportSkykeysResult{
+		Added:      []skynet.Skykey{added},
+		Duplicates: []skynet.Skykey{duplicate, added},
+		Conflicts: []skynet.SkykeyConflict{
+			{Skykey: nameConflict, Existing: existingName},
+			{Skykey: idConflict, Existing: existingID},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+
+	// Files with an invalid skykey are rejected before contacting the portal.
+	invalid := newSkykey("invalid")
+	invalid.Name = "renamed"
+	data, err := json.Marshal(map[string][]skynet.Skykey{"skykeys": {added, invalid}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ImportSkykeys(path, opts)
+	if !errors.Contains(err, skynet.ErrInvalidSkykey) {
+		t.Fatalf("expected %v, got %v", skynet.ErrInvalidSkykey, err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
@@ -0,0 +1,331 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	skynet "github.com/NebulousLabs/go-skynet/v2"
+)
+
+type (
+	// tusServer is a minimal stand-in for a portal's tus endpoint.
+	tusServer struct {
+		*httptest.Server
+
+		uploads map[string]*tusUpload
+		// location is prefixed to the paths of the upload URLs returned to
+		// clients.
+		location string
+		creates  int
+		patches  int
+		// failPatch decides whether the PATCH request with the given index
+		// fails after storing half of its chunk.
+		failPatch func(index int) bool
+		// patchOffset returns the offset reported after a PATCH request
+		// given the actual offset.
+		patchOffset func(offset int) int
+		mu          sync.Mutex
+	}
+
+	// tusUpload is an upload stored by the tusServer.
+	tusUpload struct {
+		length   int64
+		metadata string
+		data     []byte
+	}
+)
+
+// newTusServer creates and starts a new tusServer.
+func newTusServer() *tusServer {
+	ts := &tusServer{
+		uploads:     make(map[string]*tusUpload),
+		failPatch:   func(int) bool { return false },
+		patchOffset: func(offset int) int { return offset },
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.handle))
+	return ts
+}
+
+// handle handles a tus request.
+func (ts *tusServer) handle(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if r.Header.Get("Tus-Resumable") != "1.0.0" {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("Tus-Resumable", "1.0.0")
+
+	if r.Method == "POST" && r.URL.Path == "/skynet/tus" {
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ts.creates++
+		id := fmt.Sprintf("upload%d", ts.creates)
+		ts.uploads[id] = &tusUpload{
+			length:   length,
+			metadata: r.Header.Get("Upload-Metadata"),
+		}
+		w.Header().Set("Location", ts.location+"/skynet/tus/"+id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	upload, exists := ts.uploads[filepath.Base(r.URL.Path)]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.data)))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+		// Finished uploads return their skylink.
+		if int64(len(upload.data)) == upload.length {
+			w.Header().Set("Skynet-Skylink", skylink)
+		}
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		offset, err := strconv.Atoi(r.Header.Get("Upload-Offset"))
+		if err != nil || r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if offset != len(upload.data) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		chunk, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		index := ts.patches
+		ts.patches++
+		if ts.failPatch(index) {
+			upload.data = append(upload.data, chunk[:len(chunk)/2]...)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		upload.data = append(upload.data, chunk...)
+		w.Header().Set("Upload-Offset", strconv.Itoa(ts.patchOffset(len(upload.data))))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// upload returns the only upload of the server.
+func (ts *tusServer) upload(t *testing.T) *tusUpload {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if len(ts.uploads) != 1 {
+		t.Fatalf("expected 1 upload, got %v", len(ts.uploads))
+	}
+	for _, upload := range ts.uploads {
+		return upload
+	}
+	return nil
+}
+
+// TestUploadTusFile tests uploading a file in chunks using tus.
+func TestUploadTusFile(t *testing.T) {
+	ts := newTusServer()
+	defer ts.Close()
+	client := skynet.NewCustom(ts.URL, skynet.Options{})
+
+	var last skynet.Progress
+	opts := skynet.DefaultTusUploadOptions
+	opts.ChunkSize = 2
+	opts.SkykeyName = skykeyName
+	opts.Progress = func(p skynet.Progress) {
+		last = p
+	}
+	sialink2, err := client.UploadTusFile(srcFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2 != sialink {
+		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
+	}
+
+	// Check the uploaded data and metadata.
+	data, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := ts.upload(t)
+	if !bytes.Equal(upload.data, data) {
+		t.Fatalf("expected data %q, got %q", data, upload.data)
+	}
+	expectedMetadata := "filename " + base64.StdEncoding.EncodeToString([]byte("file1.txt")) +
+		",skykeyname " + base64.StdEncoding.EncodeToString([]byte(skykeyName))
+	if upload.metadata != expectedMetadata {
+		t.Fatalf("expected metadata %v, got %v", expectedMetadata, upload.metadata)
+	}
+	if ts.patches != 3 {
+		t.Fatalf("expected 3 chunks, got %v", ts.patches)
+	}
+
+	// Check the final progress.
+	if last.Phase != skynet.ProgressPhaseDone || last.BytesTransferred != int64(len(data)) {
+		t.Fatalf("unexpected final progress %+v", last)
+	}
+}
+
+// TestUploadTusRetry tests that a failed chunk is retried from the offset
+// reported by the portal.
+func TestUploadTusRetry(t *testing.T) {
+	ts := newTusServer()
+	defer ts.Close()
+	ts.failPatch = func(index int) bool {
+		return index == 2
+	}
+	client := skynet.NewCustom(ts.URL, skynet.Options{})
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	opts := skynet.DefaultTusUploadOptions
+	opts.ChunkSize = 10
+	opts.RetryInterval = 0
+	_, err := client.UploadTus(bytes.NewReader(data), int64(len(data)), "data", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ts.upload(t).data, data) {
+		t.Fatal("uploaded data does not match")
+	}
+}
+
+// TestUploadTusInvalidOffset tests that uploads fail if the portal reports
+// offsets that are out of bounds or don't advance.
+func TestUploadTusInvalidOffset(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	tests := []struct {
+		name        string
+		patchOffset func(offset int) int
+	}{
+		{"no progress", func(offset int) int { return 0 }},
+		{"beyond size", func(offset int) int { return offset + len(data) }},
+	}
+	for _, test := range tests {
+		ts := newTusServer()
+		ts.patchOffset = test.patchOffset
+		client := skynet.NewCustom(ts.URL, skynet.Options{})
+
+		opts := skynet.DefaultTusUploadOptions
+		opts.ChunkSize = 10
+		opts.RetryInterval = 0
+		_, err := client.UploadTus(bytes.NewReader(data), int64(len(data)), "data", opts)
+		ts.Close()
+		if err == nil {
+			t.Fatalf("%v: expected upload to fail", test.name)
+		}
+	}
+}
+
+// TestUploadTusResume tests that an interrupted upload is resumed by a new
+// client using the same store.
+func TestUploadTusResume(t *testing.T) {
+	ts := newTusServer()
+	defer ts.Close()
+	ts.failPatch = func(index int) bool {
+		return index >= 2
+	}
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storePath := filepath.Join(dir, "tus.json")
+	data := bytes.Repeat([]byte("0123456789"), 10)
+
+	// Upload until the portal fails.
+	client1 := skynet.NewCustom(ts.URL, skynet.Options{})
+	opts := skynet.DefaultTusUploadOptions
+	opts.ChunkSize = 10
+	opts.MaxRetries = 0
+	opts.Store = skynet.NewTusFileStore(storePath)
+	opts.Fingerprint = "data"
+	_, err = client1.UploadTus(bytes.NewReader(data), int64(len(data)), "data", opts)
+	if err == nil {
+		t.Fatal("expected upload to fail")
+	}
+	uploadURL, err := opts.Store.Get(opts.Fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadURL == "" {
+		t.Fatal("expected upload URL to be stored")
+	}
+
+	// Resume the upload with a new client and store.
+	ts.mu.Lock()
+	ts.failPatch = func(int) bool { return false }
+	ts.mu.Unlock()
+	client2 := skynet.NewCustom(ts.URL, skynet.Options{})
+	opts.Store = skynet.NewTusFileStore(storePath)
+	_, err = client2.UploadTus(bytes.NewReader(data), int64(len(data)), "data", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.creates != 1 {
+		t.Fatalf("expected upload to be resumed, but %v uploads were created", ts.creates)
+	}
+	if !bytes.Equal(ts.upload(t).data, data) {
+		t.Fatal("uploaded data does not match")
+	}
+
+	// The finished upload should be removed from the store.
+	uploadURL, err = opts.Store.Get(opts.Fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadURL != "" {
+		t.Fatalf("expected upload to be removed from store, got %v", uploadURL)
+	}
+}
+
+// TestUploadTusLocation tests that uploads are sent to the host in the
+// Location header returned by the portal, which isn't necessarily the host the
+// upload was created on.
+func TestUploadTusLocation(t *testing.T) {
+	ts := newTusServer()
+	defer ts.Close()
+	ts.location = ts.URL
+
+	// The portal only creates uploads and leaves the rest to ts.
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ts.handle(w, r)
+	}))
+	defer portal.Close()
+	client := skynet.NewCustom(portal.URL, skynet.Options{})
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	opts := skynet.DefaultTusUploadOptions
+	opts.ChunkSize = 30
+	sialink2, err := client.UploadTus(bytes.NewReader(data), int64(len(data)), "data", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2 != sialink {
+		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
+	}
+	if !bytes.Equal(ts.upload(t).data, data) {
+		t.Fatal("uploaded data does not match")
+	}
+}
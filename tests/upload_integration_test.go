@@ -1,11 +1,21 @@
 package tests
 
 import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 
 	skynet "github.com/NebulousLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -93,6 +103,48 @@ func TestUploadFileWithAPIKey(t *testing.T) {
 	}
 }
 
+// TestUploadFileWithKeystoreAPIKey tests uploading a single file with an API
+// key taken from a keystore.
+func TestUploadFileWithKeystoreAPIKey(t *testing.T) {
+	defer gock.Off()
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ks, err := skynet.OpenKeystore(filepath.Join(dir, "keystore"), "passphrase", skynet.KDFParams{Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ks.SetAPIKey(client.PortalURL, "foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload file request.
+	opts := skynet.DefaultUploadOptions
+	opts.Keystore = ks
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchHeader("Authorization", "Basic OmZvb2Jhcg==").
+		Reply(200).
+		JSON(map[string]string{"skylink": skylink})
+
+	sialink2, err := client.UploadFile(srcFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2 != sialink {
+		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
 // TestUploadCustomUserAgent tests uploading a single file with a custom user
 // agent.
 func TestUploadCustomUserAgent(t *testing.T) {
@@ -425,3 +477,461 @@ func TestUploadDirectorySkykey(t *testing.T) {
 		t.Fatal("test finished with pending mocks")
 	}
 }
+
+// TestUploadProgress tests that progress is reported during a directory
+// upload.
+func TestUploadProgress(t *testing.T) {
+	defer gock.Off()
+	gock.Observe(interceptRequest)
+
+	var updates []skynet.Progress
+	opts := skynet.DefaultUploadOptions
+	opts.Progress = func(p skynet.Progress) {
+		updates = append(updates, p)
+	}
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		Reply(200).
+		JSON(map[string]string{"skylink": skylink})
+
+	_, err := client.UploadDirectory(srcDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the phases were reported in order.
+	var phases []skynet.ProgressPhase
+	for _, u := range updates {
+		if len(phases) == 0 || phases[len(phases)-1] != u.Phase {
+			phases = append(phases, u.Phase)
+		}
+	}
+	expectedPhases := []skynet.ProgressPhase{
+		skynet.ProgressPhasePreparing,
+		skynet.ProgressPhaseSending,
+		skynet.ProgressPhaseWaiting,
+		skynet.ProgressPhaseDone,
+	}
+	if !reflect.DeepEqual(phases, expectedPhases) {
+		t.Fatalf("expected phases %v, got %v", expectedPhases, phases)
+	}
+
+	// Check that every file was reported and that all bytes were sent.
+	files := make(map[string]struct{})
+	for _, u := range updates {
+		if u.Filename != "" {
+			files[u.Filename] = struct{}{}
+		}
+	}
+	if len(files) != numFilesInDir {
+		t.Fatalf("expected progress for %v files, got %v", numFilesInDir, len(files))
+	}
+	last := updates[len(updates)-1]
+	if last.TotalBytes <= 0 || last.BytesTransferred != last.TotalBytes {
+		t.Fatalf("expected all bytes to be transferred, got %v/%v", last.BytesTransferred, last.TotalBytes)
+	}
+	if last.FileBytesTransferred != last.FileTotalBytes {
+		t.Fatalf("expected all bytes of %v to be transferred, got %v/%v", last.Filename, last.FileBytesTransferred, last.FileTotalBytes)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestUploadContentLength tests that uploads of files with a known size are
+// sent with an exact Content-Length instead of chunked encoding.
+func TestUploadContentLength(t *testing.T) {
+	var contentLength int64
+	var transferEncoding []string
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		transferEncoding = r.TransferEncoding
+		received, _ = io.Copy(ioutil.Discard, r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	// Upload a file.
+	_, err := client.UploadFile(srcFile, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transferEncoding) != 0 {
+		t.Fatalf("expected no transfer encoding, got %v", transferEncoding)
+	}
+	if contentLength <= 0 || contentLength != received {
+		t.Fatalf("expected content length %v, got %v", received, contentLength)
+	}
+
+	// Upload a directory.
+	_, err = client.UploadDirectory(srcDir, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transferEncoding) != 0 {
+		t.Fatalf("expected no transfer encoding, got %v", transferEncoding)
+	}
+	if contentLength <= 0 || contentLength != received {
+		t.Fatalf("expected content length %v, got %v", received, contentLength)
+	}
+}
+
+// TestUploadStreaming tests that uploads of unknown size are streamed without
+// buffering the whole body in memory.
+func TestUploadStreaming(t *testing.T) {
+	const size = 64 << 20
+
+	var transferEncoding []string
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transferEncoding = r.TransferEncoding
+		received, _ = io.Copy(ioutil.Discard, r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	// Hide the size of the data from the SDK.
+	data := struct{ io.Reader }{io.LimitReader(zeroReader{}, size)}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := client.Upload(skynet.UploadData{"zeros": data}, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if !reflect.DeepEqual(transferEncoding, []string{"chunked"}) {
+		t.Fatalf("expected chunked transfer encoding, got %v", transferEncoding)
+	}
+	if received <= size {
+		t.Fatalf("expected more than %v bytes, got %v", size, received)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/4 {
+		t.Fatalf("expected upload to be streamed, but %v bytes were allocated", allocated)
+	}
+}
+
+// TestUploadSizeChanged tests that an upload fails if a reader returns less
+// data than its reported size.
+func TestUploadSizeChanged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	data := shortReader{Reader: strings.NewReader("foo"), length: 10}
+	_, err := client.Upload(skynet.UploadData{"foo.txt": data}, skynet.DefaultUploadOptions)
+	if err == nil || !strings.Contains(err.Error(), "size of file foo.txt changed") {
+		t.Fatalf("expected size changed error, got %v", err)
+	}
+}
+
+// TestUploadDirectoryFileRemoved tests that a directory upload fails with
+// ErrFileChanged if a file is removed after the directory was walked.
+func TestUploadDirectoryFileRemoved(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Remove the second file once the first one is being sent.
+	opts := skynet.DefaultUploadOptions
+	opts.Progress = func(progress skynet.Progress) {
+		if progress.Filename == "a.txt" {
+			_ = os.Remove(filepath.Join(dir, "b.txt"))
+		}
+	}
+	_, err = client.UploadDirectory(dir, opts)
+	if !errors.Contains(err, skynet.ErrFileChanged) {
+		t.Fatalf("expected %v, got %v", skynet.ErrFileChanged, err)
+	}
+}
+
+// zeroReader is an io.Reader that returns an endless stream of zeros.
+type zeroReader struct{}
+
+// Read implements io.Reader.
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// shortReader is an io.Reader that reports a wrong length.
+type shortReader struct {
+	io.Reader
+	length int
+}
+
+// Len returns the reported length of the reader.
+func (sr shortReader) Len() int {
+	return sr.length
+}
+
+// TestUploadEntries tests uploading ordered entries with explicit content
+// types, modes and sizes.
+func TestUploadEntries(t *testing.T) {
+	defer gock.Off()
+	gock.Observe(interceptRequest)
+
+	opts := skynet.DefaultUploadOptions
+	opts.CustomDirname = "entries"
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("filename", "entries").
+		Reply(200).
+		JSON(map[string]string{"skylink": skylink})
+
+	interceptedRequest = ""
+
+	entries := []skynet.UploadEntry{
+		{Filename: "z.bin", Reader: strings.NewReader("zzz"), ContentType: "application/x-custom", Mode: 0755, Size: 3, SizeKnown: true},
+		{Filename: "b/index.html", Reader: strings.NewReader("<html></html>")},
+		{Filename: "empty", Reader: ioutil.NopCloser(strings.NewReader("")), ContentType: "text/plain", Size: 0, SizeKnown: true},
+		{Filename: "link", Reader: strings.NewReader("a.txt"), ContentType: "text/plain", Mode: os.ModeSymlink | 0777},
+		{Filename: "a.txt", Reader: strings.NewReader("aaa"), Mode: 0600},
+	}
+	sialink2, err := client.UploadEntries(entries, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2 != sialink {
+		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
+	}
+
+	// Check that the parts were sent in order with the expected headers.
+	expectedParts := []string{
+		"Content-Disposition: form-data; name=\"files[]\"; filename=\"z.bin\"\r\nContent-Length: 3\r\nContent-Type: application/x-custom\r\nMode: 755\r\n\r\nzzz",
+		"Content-Disposition: form-data; name=\"files[]\"; filename=\"b/index.html\"\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<html></html>",
+		"Content-Disposition: form-data; name=\"files[]\"; filename=\"empty\"\r\nContent-Length: 0\r\nContent-Type: text/plain\r\n\r\n",
+		"Content-Disposition: form-data; name=\"files[]\"; filename=\"link\"\r\nContent-Type: text/plain\r\nMode: 120777\r\n\r\na.txt",
+		"Content-Disposition: form-data; name=\"files[]\"; filename=\"a.txt\"\r\nContent-Type: text/plain; charset=utf-8\r\nMode: 600\r\n\r\naaa",
+	}
+	last := -1
+	for _, part := range expectedParts {
+		index := strings.Index(interceptedRequest, part)
+		if index < 0 {
+			t.Fatalf("did not find expected part %q", part)
+		}
+		if index < last {
+			t.Fatalf("part %q was sent out of order", part)
+		}
+		last = index
+	}
+
+	// Uploading the same filename twice should fail.
+	entries = []skynet.UploadEntry{
+		{Filename: "a.txt", Reader: strings.NewReader("a")},
+		{Filename: "a.txt", Reader: strings.NewReader("b")},
+	}
+	_, err = client.UploadEntries(entries, opts)
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("expected duplicate filename error, got %v", err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestUploadDirectoryReproducible tests that uploading identical directory
+// trees results in identical requests.
+func TestUploadDirectoryReproducible(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.URL.String()+"\n"+r.Header.Get("Content-Type")+"\n"+string(body))
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	// Upload two copies of the same tree, created at different times.
+	opts := skynet.DefaultUploadOptions
+	opts.CustomDirname = "release"
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		err = copyDir(srcDir, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.UploadDirectory(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %v", len(requests))
+	}
+	if requests[0] != requests[1] {
+		t.Fatalf("expected identical requests, got\n%v\nand\n%v", requests[0], requests[1])
+	}
+	if strings.Count(requests[0], "Content-Disposition") != numFilesInDir {
+		t.Fatalf("expected %v files sent", numFilesInDir)
+	}
+}
+
+// copyDir recursively copies the contents of the directory src to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relpath)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+}
+
+// TestUploadDirectoryWebApp tests uploading a directory with web app options.
+func TestUploadDirectoryWebApp(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_ = json.NewEncoder(w).Encode(map[string]string{"skylink": skylink})
+	}))
+	defer server.Close()
+	client := skynet.NewCustom(server.URL, skynet.Options{})
+
+	// Upload with a default path.
+	opts := skynet.DefaultUploadOptions
+	opts.DefaultPath = "index.html"
+	_, err := client.UploadDirectory(srcDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("defaultpath") != "/index.html" {
+		t.Fatalf("expected defaultpath /index.html, got %v", query.Get("defaultpath"))
+	}
+
+	// Upload with try files and error pages.
+	opts = skynet.DefaultUploadOptions
+	opts.TryFiles = []string{"index.html", "/file1.txt"}
+	opts.ErrorPages = map[int]string{404: "/dir1/file3.txt"}
+	_, err = client.UploadDirectory(srcDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("tryfiles") != `["index.html","/file1.txt"]` {
+		t.Fatalf("unexpected tryfiles %v", query.Get("tryfiles"))
+	}
+	if query.Get("errorpages") != `{"404":"/dir1/file3.txt"}` {
+		t.Fatalf("unexpected errorpages %v", query.Get("errorpages"))
+	}
+	if query.Get("defaultpath") != "" {
+		t.Fatalf("did not expect defaultpath, got %v", query.Get("defaultpath"))
+	}
+
+	// Invalid options should fail before anything is sent.
+	tests := []struct {
+		name   string
+		modify func(*skynet.UploadOptions)
+	}{
+		{"default path and disable default path", func(o *skynet.UploadOptions) {
+			o.DefaultPath = "index.html"
+			o.DisableDefaultPath = true
+		}},
+		{"missing default path", func(o *skynet.UploadOptions) { o.DefaultPath = "missing.html" }},
+		{"nested default path", func(o *skynet.UploadOptions) { o.DefaultPath = "dir1/file3.txt" }},
+		{"try files and default path", func(o *skynet.UploadOptions) {
+			o.DefaultPath = "index.html"
+			o.TryFiles = []string{"index.html"}
+		}},
+		{"two absolute try files", func(o *skynet.UploadOptions) { o.TryFiles = []string{"/index.html", "/file1.txt"} }},
+		{"missing absolute try file", func(o *skynet.UploadOptions) { o.TryFiles = []string{"/missing.html"} }},
+		{"empty try file", func(o *skynet.UploadOptions) { o.TryFiles = []string{""} }},
+		{"error page status code", func(o *skynet.UploadOptions) { o.ErrorPages = map[int]string{200: "/index.html"} }},
+		{"relative error page", func(o *skynet.UploadOptions) { o.ErrorPages = map[int]string{404: "index.html"} }},
+		{"missing error page", func(o *skynet.UploadOptions) { o.ErrorPages = map[int]string{404: "/404.html"} }},
+	}
+	for _, test := range tests {
+		query = nil
+		opts := skynet.DefaultUploadOptions
+		test.modify(&opts)
+		_, err := client.UploadDirectory(srcDir, opts)
+		if err == nil {
+			t.Fatalf("%v: expected upload to fail", test.name)
+		}
+		if query != nil {
+			t.Fatalf("%v: expected no request to be sent", test.name)
+		}
+	}
+
+	// A default path is not allowed for single files.
+	opts = skynet.DefaultUploadOptions
+	opts.DefaultPath = "file1.txt"
+	_, err = client.UploadFile(srcFile, opts)
+	if err == nil {
+		t.Fatal("expected single file upload with default path to fail")
+	}
+}
+
+// TestUploadDryRun tests that dry-run uploads set the dryrun parameter and
+// that the returned skylink matches the computed one.
+func TestUploadDryRun(t *testing.T) {
+	defer gock.Off()
+
+	computed, err := skynet.ComputeFileSkylink(srcFile, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := skynet.DefaultUploadOptions
+	opts.DryRun = true
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("dryrun", "true").
+		Reply(200).
+		JSON(map[string]string{"skylink": strings.TrimPrefix(computed, skynet.URISkynetPrefix)})
+
+	sialink2, err := client.UploadFile(srcFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2 != computed {
+		t.Fatalf("expected sialink %v, got %v", computed, sialink2)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
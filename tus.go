@@ -0,0 +1,459 @@
+package skynet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// TusUploadOptions contains the options used for resumable uploads using
+	// the tus protocol.
+	TusUploadOptions struct {
+		Options
+
+		// ChunkSize is the number of bytes sent with each PATCH request.
+		ChunkSize int64
+		// MaxRetries is the number of times a failed chunk is retried before
+		// the upload is aborted.
+		MaxRetries int
+		// RetryInterval is the time to wait before the first retry. It is
+		// doubled with every further retry.
+		RetryInterval time.Duration
+
+		// CustomFilename is the custom filename to use for the upload. If this
+		// is empty, the filename of the file being uploaded will be used by
+		// default.
+		CustomFilename string
+
+		// SkykeyName is the name of the skykey used to encrypt the upload.
+		SkykeyName string
+		// SkykeyID is the ID of the skykey used to encrypt the upload.
+		SkykeyID string
+
+		// Store, if set, persists the URLs of unfinished uploads so that they
+		// can be resumed after the process restarts.
+		Store TusStore
+		// Fingerprint identifies the upload in the Store. UploadTusFile
+		// derives it from the path, size and modification time of the file
+		// if it is empty.
+		Fingerprint string
+
+		// Progress, if set, is called with progress updates during the upload.
+		Progress ProgressFunc
+	}
+
+	// TusStore persists the URLs of unfinished tus uploads, indexed by
+	// fingerprint.
+	TusStore interface {
+		// Get returns the upload URL for the fingerprint, or "" if there is
+		// none.
+		Get(fingerprint string) (string, error)
+		// Set stores the upload URL for the fingerprint.
+		Set(fingerprint, uploadURL string) error
+		// Delete removes the upload URL for the fingerprint.
+		Delete(fingerprint string) error
+	}
+
+	// TusFileStore is a TusStore that persists upload URLs in a JSON file.
+	TusFileStore struct {
+		path string
+		mu   sync.Mutex
+	}
+)
+
+const (
+	// tusVersion is the version of the tus protocol used.
+	tusVersion = "1.0.0"
+)
+
+var (
+	// DefaultTusUploadOptions contains the default tus upload options.
+	DefaultTusUploadOptions = TusUploadOptions{
+		Options: DefaultOptions("/skynet/tus"),
+
+		// Portals expect chunks to be a multiple of 40 MiB, which is the
+		// size of a chunk of the fanout.
+		ChunkSize:      40 << 20,
+		MaxRetries:     3,
+		RetryInterval:  time.Second,
+		CustomFilename: "",
+		SkykeyName:     "",
+		SkykeyID:       "",
+		Store:          nil,
+		Fingerprint:    "",
+	}
+)
+
+// NewTusFileStore creates a TusStore that persists upload URLs in the JSON
+// file at path. The file is created on the first write.
+func NewTusFileStore(path string) *TusFileStore {
+	return &TusFileStore{path: path}
+}
+
+// Get implements TusStore.
+func (s *TusFileStore) Get(fingerprint string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	return uploads[fingerprint], nil
+}
+
+// Set implements TusStore.
+func (s *TusFileStore) Set(fingerprint, uploadURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads, err := s.load()
+	if err != nil {
+		return err
+	}
+	uploads[fingerprint] = uploadURL
+	return s.save(uploads)
+}
+
+// Delete implements TusStore.
+func (s *TusFileStore) Delete(fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, exists := uploads[fingerprint]; !exists {
+		return nil
+	}
+	delete(uploads, fingerprint)
+	return s.save(uploads)
+}
+
+// load reads the uploads from disk.
+func (s *TusFileStore) load() (map[string]string, error) {
+	uploads := make(map[string]string)
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return uploads, nil
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read tus store")
+	}
+	err = json.Unmarshal(data, &uploads)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not unmarshal tus store")
+	}
+	return uploads, nil
+}
+
+// save atomically writes the uploads to disk.
+func (s *TusFileStore) save(uploads map[string]string) error {
+	data, err := json.Marshal(uploads)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal tus store")
+	}
+	return errors.AddContext(writeFileAtomic(s.path, data), "could not write tus store")
+}
+
+// UploadTusFile uploads a file to Skynet using the tus protocol and returns
+// the skylink. If opts.Store is set, an interrupted upload of the same file is
+// resumed.
+func (sc *SkynetClient) UploadTusFile(path string, opts TusUploadOptions) (skylink string, err error) {
+	path = gopath.Clean(path)
+
+	// Open the file.
+	file, err := os.Open(gopath.Clean(path)) // Clean again to prevent lint error.
+	if err != nil {
+		return "", errors.AddContext(err, fmt.Sprintf("could not open file %v", path))
+	}
+	defer func() {
+		err = errors.Extend(err, file.Close())
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return "", errors.AddContext(err, fmt.Sprintf("could not stat file %v", path))
+	}
+
+	// Set filename.
+	filename := filepath.Base(path)
+	if opts.CustomFilename != "" {
+		filename = opts.CustomFilename
+	}
+
+	// Set fingerprint.
+	if opts.Fingerprint == "" {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return "", errors.AddContext(err, "could not get absolute path")
+		}
+		opts.Fingerprint = fmt.Sprintf("%s-%d-%d", absPath, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return sc.UploadTus(file, info.Size(), filename, opts)
+}
+
+// UploadTus uploads size bytes of data to Skynet using the tus protocol and
+// returns the skylink. Chunks that fail to upload are retried from the offset
+// reported by the portal. If opts.Store and opts.Fingerprint are set, an
+// interrupted upload with the same fingerprint is resumed.
+func (sc *SkynetClient) UploadTus(data io.ReadSeeker, size int64, filename string, opts TusUploadOptions) (skylink string, err error) {
+	if opts.ChunkSize <= 0 {
+		return "", errors.New("ChunkSize must be positive")
+	}
+	persist := opts.Store != nil && opts.Fingerprint != ""
+
+	tracker := newProgressTracker(opts.Progress)
+	tracker.setPhase(ProgressPhasePreparing)
+	tracker.setTotal(size)
+
+	// Try to resume a previous upload.
+	var uploadURL string
+	offset := int64(-1)
+	if persist {
+		uploadURL, err = opts.Store.Get(opts.Fingerprint)
+		if err != nil {
+			return "", errors.AddContext(err, "could not get upload from store")
+		}
+	}
+	if uploadURL != "" {
+		offset, err = sc.tusOffset(uploadURL, opts)
+		if err != nil || offset > size {
+			// The upload has most likely expired or belongs to different
+			// data, start over.
+			offset = -1
+		}
+	}
+
+	// Create a new upload.
+	if offset < 0 {
+		uploadURL, err = sc.tusCreate(size, filename, opts)
+		if err != nil {
+			return "", errors.AddContext(err, "could not create upload")
+		}
+		offset = 0
+		if persist {
+			err = opts.Store.Set(opts.Fingerprint, uploadURL)
+			if err != nil {
+				return "", errors.AddContext(err, "could not add upload to store")
+			}
+		}
+	}
+
+	// Send the data.
+	tracker.startFile(filename, size)
+	tracker.setPhase(ProgressPhaseSending)
+	retries := 0
+	retryInterval := opts.RetryInterval
+	for offset < size {
+		tracker.setTransferred(offset)
+		_, err = data.Seek(offset, io.SeekStart)
+		if err != nil {
+			return "", errors.AddContext(err, "could not seek to upload offset")
+		}
+		length := size - offset
+		if length > opts.ChunkSize {
+			length = opts.ChunkSize
+		}
+		chunk := &fileProgressReader{
+			Reader:  newProgressReader(io.LimitReader(data, length), tracker, ProgressPhaseSending),
+			tracker: tracker,
+		}
+		newOffset, chunkErr := sc.tusPatch(uploadURL, offset, length, chunk, opts)
+		if chunkErr == nil && newOffset > size {
+			return "", fmt.Errorf("portal reported offset %v beyond the upload size %v", newOffset, size)
+		}
+		// A chunk that doesn't advance the offset is retried like a failed
+		// one, so that the upload doesn't loop forever.
+		if chunkErr == nil && newOffset <= offset {
+			chunkErr = fmt.Errorf("portal reported offset %v after a chunk at offset %v", newOffset, offset)
+		}
+		if chunkErr == nil {
+			offset = newOffset
+			retries = 0
+			retryInterval = opts.RetryInterval
+			continue
+		}
+
+		// Retry from the offset the portal has, after a while.
+		if retries >= opts.MaxRetries {
+			return "", errors.AddContext(chunkErr, fmt.Sprintf("could not upload chunk at offset %v", offset))
+		}
+		retries++
+		time.Sleep(retryInterval)
+		retryInterval *= 2
+		headOffset, err := sc.tusOffset(uploadURL, opts)
+		if err == nil && headOffset > size {
+			return "", fmt.Errorf("portal reported offset %v beyond the upload size %v", headOffset, size)
+		}
+		if err == nil {
+			offset = headOffset
+		}
+	}
+	tracker.setTransferred(size)
+	tracker.setPhase(ProgressPhaseWaiting)
+
+	skylink, err = sc.tusSkylink(uploadURL, opts)
+	if err != nil {
+		return "", errors.AddContext(err, "could not get skylink of finished upload")
+	}
+	if persist {
+		err = opts.Store.Delete(opts.Fingerprint)
+		if err != nil {
+			return "", errors.AddContext(err, "could not remove upload from store")
+		}
+	}
+	tracker.setPhase(ProgressPhaseDone)
+
+	return fmt.Sprintf("%s%s", URISkynetPrefix, skylink), nil
+}
+
+// tusCreate creates a new tus upload and returns its URL.
+func (sc *SkynetClient) tusCreate(size int64, filename string, opts TusUploadOptions) (string, error) {
+	metadata := map[string]string{
+		"filename": filename,
+	}
+	if opts.SkykeyName != "" {
+		metadata["skykeyname"] = opts.SkykeyName
+	}
+	if opts.SkykeyID != "" {
+		metadata["skykeyid"] = opts.SkykeyID
+	}
+
+	headers := tusHeaders()
+	headers.Set("Upload-Length", strconv.FormatInt(size, 10))
+	headers.Set("Upload-Metadata", encodeTusMetadata(metadata))
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			method:  "POST",
+			reqBody: http.NoBody,
+			headers: headers,
+		},
+	)
+	if err != nil {
+		return "", errors.AddContext(err, "could not execute request")
+	}
+	_, err = parseResponseBody(resp)
+	if err != nil {
+		return "", errors.AddContext(err, "could not parse response body")
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", errors.AddContext(err, "could not get upload location")
+	}
+	return location.String(), nil
+}
+
+// tusOffset returns the offset of the tus upload at the given URL.
+func (sc *SkynetClient) tusOffset(uploadURL string, opts TusUploadOptions) (int64, error) {
+	resp, err := sc.tusHead(uploadURL, opts)
+	if err != nil {
+		return 0, err
+	}
+	return parseUploadOffset(resp)
+}
+
+// tusPatch sends a chunk of a tus upload and returns the new offset.
+func (sc *SkynetClient) tusPatch(uploadURL string, offset, length int64, chunk io.Reader, opts TusUploadOptions) (int64, error) {
+	headers := tusHeaders()
+	headers.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	opts.customContentType = "application/offset+octet-stream"
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:       opts.Options,
+			method:        "PATCH",
+			reqBody:       chunk,
+			headers:       headers,
+			rawURL:        uploadURL,
+			contentLength: length,
+		},
+	)
+	if err != nil {
+		return 0, errors.AddContext(err, "could not execute request")
+	}
+	_, err = parseResponseBody(resp)
+	if err != nil {
+		return 0, errors.AddContext(err, "could not parse response body")
+	}
+	return parseUploadOffset(resp)
+}
+
+// tusSkylink returns the skylink of the finished tus upload at the given URL,
+// which portals return in the Skynet-Skylink header.
+func (sc *SkynetClient) tusSkylink(uploadURL string, opts TusUploadOptions) (string, error) {
+	resp, err := sc.tusHead(uploadURL, opts)
+	if err != nil {
+		return "", err
+	}
+	skylink := resp.Header.Get("Skynet-Skylink")
+	if skylink == "" {
+		return "", errors.New("portal did not return the skylink")
+	}
+	return skylink, nil
+}
+
+// tusHead sends a HEAD request for the tus upload at the given URL.
+func (sc *SkynetClient) tusHead(uploadURL string, opts TusUploadOptions) (*http.Response, error) {
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			method:  "HEAD",
+			reqBody: http.NoBody,
+			headers: tusHeaders(),
+			rawURL:  uploadURL,
+		},
+	)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not execute request")
+	}
+	_, err = parseResponseBody(resp)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not parse response body")
+	}
+	return resp, nil
+}
+
+// tusHeaders returns the headers required by every tus request.
+func tusHeaders() http.Header {
+	headers := make(http.Header)
+	headers.Set("Tus-Resumable", tusVersion)
+	return headers
+}
+
+// encodeTusMetadata encodes metadata for the Upload-Metadata header.
+func encodeTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseUploadOffset parses the Upload-Offset header of a tus response.
+func parseUploadOffset(resp *http.Response) (int64, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, errors.AddContext(err, "could not parse Upload-Offset header")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid Upload-Offset %v", offset)
+	}
+	return offset, nil
+}
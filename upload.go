@@ -1,18 +1,14 @@
 package skynet
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
-	"net/http"
-	"net/textproto"
 	"net/url"
 	"os"
 	gopath "path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"gitlab.com/NebulousLabs/errors"
@@ -22,6 +18,32 @@ type (
 	// UploadData contains data to upload, indexed by filenames.
 	UploadData map[string]io.Reader
 
+	// UploadEntry contains a single file to upload with UploadEntries.
+	UploadEntry struct {
+		// Filename is the name of the file, including its path within the
+		// uploaded directory.
+		Filename string
+		// Reader contains the data of the file.
+		Reader io.Reader
+
+		// ContentType is the content type of the file. If this is empty, it
+		// is detected from the filename and the data as configured by the
+		// upload options.
+		ContentType string
+		// Mode is the file mode of the file. It is sent as a Unix mode with
+		// the permission bits and, for files other than regular files, the
+		// file type bits. It is not sent if it is zero.
+		Mode os.FileMode
+		// Size is the size of the file in bytes. It is only used if SizeKnown
+		// is set, otherwise the size is inferred from Reader if possible.
+		// Uploads are sent with an exact Content-Length if the sizes of all
+		// files are known.
+		Size int64
+		// SizeKnown indicates that Size is set, which allows empty files to
+		// declare their size.
+		SizeKnown bool
+	}
+
 	// UploadOptions contains the options used for uploads.
 	UploadOptions struct {
 		Options
@@ -45,6 +67,84 @@ type (
 		SkykeyName string
 		// SkykeyID is the ID of the skykey used to encrypt the upload.
 		SkykeyID string
+		// EncryptionKey, if set, encrypts every file before it is uploaded,
+		// so that the portal never sees the plaintext. Encrypted files are
+		// uploaded with the application/octet-stream content type and can't
+		// be combined with skykeys.
+		EncryptionKey *EncryptionKey
+
+		// DryRun makes the portal compute and return the skylink without
+		// storing the upload.
+		DryRun bool
+		// FanoutDataPieces and FanoutParityPieces are the erasure coding
+		// parameters portals use for uploads that don't fit in a base
+		// sector. They are only used to compute skylinks offline.
+		FanoutDataPieces   int
+		FanoutParityPieces int
+
+		// Progress, if set, is called with progress updates during the upload.
+		Progress ProgressFunc
+
+		// ContentTypes maps lowercase file extensions, such as ".wasm", to
+		// the content types of files with them. It takes precedence over the
+		// built-in mappings.
+		ContentTypes map[string]string
+		// DetectContentType, if set, is called first to detect the content
+		// type of files without an explicit content type. If it returns "",
+		// the content type is inferred from the extension of the file, or
+		// else sniffed from its data.
+		DetectContentType ContentTypeFunc
+
+		// IncludePatterns, if set, limits directory uploads to files matching
+		// at least one of the patterns. Patterns use gitignore syntax and are
+		// relative to the uploaded directory.
+		IncludePatterns []string
+		// ExcludePatterns excludes files and directories matching any of the
+		// patterns from directory uploads. Patterns use gitignore syntax and
+		// are relative to the uploaded directory.
+		ExcludePatterns []string
+		// IgnoreFilename is the name of the ignore files respected by
+		// directory uploads. Ignore files use gitignore syntax and apply to
+		// the directory they are in. If this is empty, ignore files are not
+		// used.
+		IgnoreFilename string
+		// SkipHidden excludes files and directories whose name starts with a
+		// dot from directory uploads.
+		SkipHidden bool
+		// MaxFileSize, if positive, makes directory uploads fail with
+		// ErrFileTooLarge if a file is larger than this many bytes.
+		MaxFileSize int64
+		// Symlinks decides how directory uploads handle symbolic links.
+		Symlinks SymlinkPolicy
+
+		// DefaultPath is the path of the file the portal serves when a
+		// directory upload is accessed without a path. It must be a file at
+		// the root of the directory.
+		DefaultPath string
+		// DisableDefaultPath disables serving a default file for directory
+		// uploads.
+		DisableDefaultPath bool
+		// TryFiles is a list of files the portal tries in order if the
+		// requested path does not exist. Relative paths are resolved against
+		// the requested path. At most one path may be absolute, such as
+		// "/index.html" as the fallback of a single-page app, and it must
+		// exist. TryFiles can't be combined with DefaultPath or
+		// DisableDefaultPath.
+		TryFiles []string
+		// ErrorPages maps HTTP status codes between 400 and 599 to the
+		// absolute path of the file the portal serves with them, such as
+		// 404: "/404.html".
+		ErrorPages map[int]string
+	}
+
+	// SymlinkPolicy decides how directory uploads handle symbolic links.
+	SymlinkPolicy int
+
+	// uploadRequest is a validated upload that is ready to be sent to a
+	// portal or to have its skylink computed.
+	uploadRequest struct {
+		query url.Values
+		parts []uploadPart
 	}
 
 	// UploadResponse contains the response for uploads.
@@ -54,6 +154,26 @@ type (
 	}
 )
 
+const (
+	// SymlinkFollow uploads the files that symbolic links point to and the
+	// contents of linked directories. Links to a directory that contains the
+	// link are skipped to avoid cycles, while other files and directories
+	// that are linked more than once are uploaded under every path.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkStore uploads symbolic links as files containing the link target
+	// and the file mode of a symbolic link, which is sent as a Unix mode with
+	// the S_IFLNK file type.
+	SymlinkStore
+	// SymlinkSkip skips symbolic links.
+	SymlinkSkip
+)
+
+const (
+	// DefaultIgnoreFilename is the default name of ignore files for directory
+	// uploads.
+	DefaultIgnoreFilename = ".skynetignore"
+)
+
 var (
 	// DefaultUploadOptions contains the default upload options.
 	DefaultUploadOptions = UploadOptions{
@@ -65,24 +185,122 @@ var (
 		CustomDirname:                "",
 		SkykeyName:                   "",
 		SkykeyID:                     "",
+		FanoutDataPieces:             DefaultFanoutDataPieces,
+		FanoutParityPieces:           DefaultFanoutParityPieces,
+		IgnoreFilename:               DefaultIgnoreFilename,
+		Symlinks:                     SymlinkFollow,
 	}
 )
 
-// Upload uploads the given generic data and returns the skylink.
+// Upload uploads the given generic data and returns the skylink. The files are
+// uploaded in the order of their filenames.
 func (sc *SkynetClient) Upload(uploadData UploadData, opts UploadOptions) (skylink string, err error) {
-	// prepare formdata
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	filenames := make([]string, 0, len(uploadData))
+	for filename := range uploadData {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	entries := make([]UploadEntry, 0, len(uploadData))
+	for _, filename := range filenames {
+		entries = append(entries, UploadEntry{Filename: filename, Reader: uploadData[filename]})
+	}
+	return sc.UploadEntries(entries, opts)
+}
+
+// UploadEntries uploads the given files in the given order and returns the
+// skylink.
+func (sc *SkynetClient) UploadEntries(entries []UploadEntry, opts UploadOptions) (skylink string, err error) {
+	tracker := newProgressTracker(opts.Progress)
+	tracker.setPhase(ProgressPhasePreparing)
+
+	req, err := newUploadRequest(entries, opts)
+	if err != nil {
+		return "", err
+	}
+	body, err := newMultipartBody(req.parts, tracker)
+	if err != nil {
+		return "", errors.AddContext(err, "could not create request body")
+	}
+	opts.customContentType = body.contentType
+
+	// Report the progress of sending the body if requested.
+	var reqBody io.Reader = body
+	if tracker != nil {
+		tracker.setTotal(body.contentLength)
+		reqBody = newProgressReader(body, tracker, ProgressPhaseWaiting)
+	}
+	tracker.setPhase(ProgressPhaseSending)
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:       opts.Options,
+			method:        "POST",
+			reqBody:       reqBody,
+			query:         req.query,
+			contentLength: body.contentLength,
+		},
+	)
+	writeErr := body.writeErr()
+	if err != nil {
+		return "", errors.AddContext(errors.Compose(writeErr, err), "could not execute request")
+	}
+	if writeErr != nil {
+		return "", errors.AddContext(writeErr, "could not write request body")
+	}
+	tracker.setPhase(ProgressPhaseWaiting)
 
+	respBody, err := parseResponseBody(resp)
+	if err != nil {
+		return "", errors.AddContext(err, "could not parse response body")
+	}
+
+	var apiResponse UploadResponse
+	err = json.Unmarshal(respBody.Bytes(), &apiResponse)
+	if err != nil {
+		return "", errors.AddContext(err, "could not unmarshal response JSON")
+	}
+	tracker.setPhase(ProgressPhaseDone)
+
+	return fmt.Sprintf("%s%s", URISkynetPrefix, apiResponse.Skylink), nil
+}
+
+// UploadFile uploads a file to Skynet and returns the skylink.
+func (sc *SkynetClient) UploadFile(path string, opts UploadOptions) (skylink string, err error) {
+	entry, file, err := fileEntry(path, opts)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errors.Extend(err, file.Close())
+	}()
+	return sc.UploadEntries([]UploadEntry{entry}, opts)
+}
+
+// UploadDirectory uploads a local directory to Skynet and returns the skylink.
+func (sc *SkynetClient) UploadDirectory(path string, opts UploadOptions) (skylink string, err error) {
+	entries, err := directoryEntries(path, &opts)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errors.Compose(err, closeLazyFiles(entries))
+	}()
+	return sc.UploadEntries(entries, opts)
+}
+
+// newUploadRequest validates the given files and options and prepares the
+// query and the parts of the upload.
+func newUploadRequest(entries []UploadEntry, opts UploadOptions) (uploadRequest, error) {
 	var fieldname string
 	var filename string
 	// Upload as a directory if the dirname is set, even if there is only 1
 	// file.
-	if len(uploadData) == 1 && opts.CustomDirname == "" {
+	if len(entries) == 1 && opts.CustomDirname == "" {
 		fieldname = opts.PortalFileFieldName
 	} else {
 		if opts.CustomDirname == "" {
-			return "", errors.New("CustomDirname must be set when uploading multiple files")
+			return uploadRequest{}, errors.New("CustomDirname must be set when uploading multiple files")
 		}
 		fieldname = opts.PortalDirectoryFileFieldName
 		filename = opts.CustomDirname
@@ -100,100 +318,91 @@ func (sc *SkynetClient) Upload(uploadData UploadData, opts UploadOptions) (skyli
 	if opts.SkykeyID != "" {
 		values.Set("skykeyid", opts.SkykeyID)
 	}
-
-	for filename, data := range uploadData {
-		// We may need to do a read to determine the Content-Type. Tee the read
-		// into a buffer so we can read again.
-		var buf bytes.Buffer
-		tee := io.TeeReader(data, &buf)
-		// Create the form file, inferring the Content-Type.
-		part, err := createFormFileContentType(writer, fieldname, filename, tee)
-		if err != nil {
-			return "", errors.AddContext(err, fmt.Sprintf("could not create form file for file %v", filename))
-		}
-		// Copy from the buffer and then the rest of the data that hasn't been
-		// read.
-		_, err = io.Copy(part, &buf)
-		_, err2 := io.Copy(part, data)
-		if errors.Compose(err, err2) != nil {
-			return "", errors.AddContext(err, fmt.Sprintf("could not copy data for file %v", filename))
-		}
+	if opts.DryRun {
+		values.Set("dryrun", "true")
 	}
 
-	err = writer.Close()
-	if err != nil {
-		return "", errors.AddContext(err, "could not close writer")
+	if opts.EncryptionKey != nil && (opts.SkykeyName != "" || opts.SkykeyID != "") {
+		return uploadRequest{}, errors.New("EncryptionKey can't be combined with a skykey")
 	}
-	opts.customContentType = writer.FormDataContentType()
 
-	resp, err := sc.executeRequest(
-		requestOptions{
-			Options: opts.Options,
-			method:  "POST",
-			reqBody: body,
-			query:   values,
-		},
-	)
-	if err != nil {
-		return "", errors.AddContext(err, "could not execute request")
+	// Validate the files and the web app options before reading anything.
+	filenames := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if entry.Filename == "" {
+			return uploadRequest{}, errors.New("filename of upload entry must be set")
+		}
+		if _, exists := filenames[entry.Filename]; exists {
+			return uploadRequest{}, fmt.Errorf("file %v is uploaded more than once", entry.Filename)
+		}
+		filenames[entry.Filename] = struct{}{}
 	}
-
-	respBody, err := parseResponseBody(resp)
-	if err != nil {
-		return "", errors.AddContext(err, "could not parse response body")
+	subfiles := filenames
+	if fieldname != opts.PortalDirectoryFileFieldName {
+		subfiles = nil
 	}
-
-	var apiResponse UploadResponse
-	err = json.Unmarshal(respBody.Bytes(), &apiResponse)
+	err := setWebAppOptions(values, opts, subfiles)
 	if err != nil {
-		return "", errors.AddContext(err, "could not unmarshal response JSON")
+		return uploadRequest{}, errors.AddContext(err, "invalid upload options")
 	}
 
-	return fmt.Sprintf("%s%s", URISkynetPrefix, apiResponse.Skylink), nil
+	// prepare formdata
+	parts := make([]uploadPart, 0, len(entries))
+	for _, entry := range entries {
+		if opts.EncryptionKey != nil {
+			entry, err = encryptEntry(entry, opts.EncryptionKey)
+			if err != nil {
+				return uploadRequest{}, errors.AddContext(err, fmt.Sprintf("could not encrypt file %v", entry.Filename))
+			}
+		}
+		part, err := newUploadPart(fieldname, entry, opts)
+		if err != nil {
+			return uploadRequest{}, errors.AddContext(err, fmt.Sprintf("could not create form file for file %v", entry.Filename))
+		}
+		parts = append(parts, part)
+	}
+	return uploadRequest{query: values, parts: parts}, nil
 }
 
-// UploadFile uploads a file to Skynet and returns the skylink.
-func (sc *SkynetClient) UploadFile(path string, opts UploadOptions) (skylink string, err error) {
+// fileEntry opens the file at path and returns the entry UploadFile uploads
+// for it. The file must be closed by the caller.
+func fileEntry(path string, opts UploadOptions) (UploadEntry, *os.File, error) {
 	path = gopath.Clean(path)
 
 	// Open the file.
 	file, err := os.Open(gopath.Clean(path)) // Clean again to prevent lint error.
 	if err != nil {
-		return "", errors.AddContext(err, fmt.Sprintf("could not open file %v", path))
+		return UploadEntry{}, nil, errors.AddContext(err, fmt.Sprintf("could not open file %v", path))
 	}
-	defer func() {
-		err = errors.Extend(err, file.Close())
-	}()
 
 	// Set filename.
 	filename := filepath.Base(path)
 	if opts.CustomFilename != "" {
 		filename = opts.CustomFilename
 	}
-
-	uploadData := make(UploadData)
-	uploadData[filename] = file
-
-	return sc.Upload(uploadData, opts)
+	return UploadEntry{Filename: filename, Reader: file}, file, nil
 }
 
-// UploadDirectory uploads a local directory to Skynet and returns the skylink.
-func (sc *SkynetClient) UploadDirectory(path string, opts UploadOptions) (skylink string, err error) {
+// directoryEntries walks the directory at path and returns the entries
+// UploadDirectory uploads for it. It sets the CustomDirname of opts to the base
+// name of the directory if it is not set. The files are opened lazily and must
+// be closed with closeLazyFiles once the upload is done.
+func directoryEntries(path string, opts *UploadOptions) ([]UploadEntry, error) {
 	path = gopath.Clean(path)
 
 	// Verify the given path is a directory.
 	info, err := os.Stat(path)
 	if err != nil {
-		return "", errors.AddContext(err, "error retrieving path info")
+		return nil, errors.AddContext(err, "error retrieving path info")
 	}
 	if !info.IsDir() {
-		return "", fmt.Errorf("given path %v is not a directory", path)
+		return nil, fmt.Errorf("given path %v is not a directory", path)
 	}
 
 	// Find all files in the given directory.
-	files, err := walkDirectory(path)
+	files, err := walkDirectory(path, *opts)
 	if err != nil {
-		return "", errors.AddContext(err, "error walking directory")
+		return nil, errors.AddContext(err, "error walking directory")
 	}
 
 	// Set DirName.
@@ -202,63 +411,110 @@ func (sc *SkynetClient) UploadDirectory(path string, opts UploadOptions) (skylin
 	}
 
 	// prepare formdata
-	uploadData := make(UploadData)
-	basepath := path
-	if basepath != "/" {
-		basepath += "/"
-	}
-	for _, filepath := range files {
-		file, err := os.Open(gopath.Clean(filepath)) // Clean again to prevent lint error.
+	entries := make([]UploadEntry, 0, len(files))
+	for _, file := range files {
+		// Store symlinks as their target.
+		if file.linkTarget != "" {
+			entries = append(entries, UploadEntry{
+				Filename: file.relpath,
+				Reader:   strings.NewReader(file.linkTarget),
+				Mode:     file.mode,
+			})
+			continue
+		}
+		// Files are only opened while they are being uploaded. Infer the
+		// content type now, which only opens the file briefly if it can't be
+		// inferred from the filename.
+		reader := newLazyFile(file)
+		contentType, _, err := fileContentType(file.relpath, reader, *opts)
+		err = errors.Compose(err, reader.Close())
 		if err != nil {
-			return "", errors.AddContext(err, "error opening file")
+			return nil, errors.AddContext(err, fmt.Sprintf("could not get content type of file %v", file.path))
 		}
-		// Remove the base path before uploading. Any ending '/' was removed
-		// from `path` with `Clean`.
-		filepath = strings.TrimPrefix(filepath, basepath)
-		uploadData[filepath] = file
+		// Upload the files under their paths relative to the directory, with
+		// forward slashes on all platforms.
+		entries = append(entries, UploadEntry{
+			Filename:    file.relpath,
+			Reader:      reader,
+			ContentType: contentType,
+		})
 	}
-
-	return sc.Upload(uploadData, opts)
+	// Sort the files so that uploading the same directory always results in
+	// the same request.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Filename < entries[j].Filename
+	})
+	return entries, nil
 }
 
-// createFormFileContentType is based on multipart.Writer.CreateFormFile, except
-// it properly sets the content types.
-func createFormFileContentType(w *multipart.Writer, fieldname, filename string, file io.Reader) (io.Writer, error) {
-	escapeQuotes := func(s string) string {
-		var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
-		return quoteEscaper.Replace(s)
+// setWebAppOptions validates the web app options and sets them in values.
+// subfiles contains the files of a directory upload and is nil for single file
+// uploads.
+func setWebAppOptions(values url.Values, opts UploadOptions, subfiles map[string]struct{}) error {
+	if opts.DefaultPath != "" {
+		if opts.DisableDefaultPath {
+			return errors.New("DefaultPath and DisableDefaultPath can't both be set")
+		}
+		if subfiles == nil {
+			return errors.New("DefaultPath is not allowed for single file uploads")
+		}
+		defaultPath := strings.TrimPrefix(opts.DefaultPath, "/")
+		if strings.Contains(defaultPath, "/") {
+			return fmt.Errorf("DefaultPath %v must refer to a file at the root of the directory", opts.DefaultPath)
+		}
+		if _, exists := subfiles[defaultPath]; !exists {
+			return fmt.Errorf("DefaultPath %v does not exist", opts.DefaultPath)
+		}
+		values.Set("defaultpath", "/"+defaultPath)
 	}
-
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
-			escapeQuotes(fieldname), escapeQuotes(filename)))
-	contentType, err := getFileContentType(filename, file)
-	if err != nil {
-		return nil, err
+	if opts.DisableDefaultPath {
+		values.Set("disabledefaultpath", "true")
 	}
-	h.Set("Content-Type", contentType)
-	return w.CreatePart(h)
-}
 
-// getFileContentType extracts the content type from a given file.
-func getFileContentType(filename string, file io.Reader) (string, error) {
-	contentType := mime.TypeByExtension(filepath.Ext(filename))
-	if contentType != "" {
-		return contentType, nil
+	if len(opts.TryFiles) > 0 {
+		if opts.DefaultPath != "" || opts.DisableDefaultPath {
+			return errors.New("TryFiles can't be combined with DefaultPath or DisableDefaultPath")
+		}
+		absolute := false
+		for _, tryFile := range opts.TryFiles {
+			if tryFile == "" {
+				return errors.New("TryFiles can't contain empty paths")
+			}
+			if !strings.HasPrefix(tryFile, "/") {
+				continue
+			}
+			if absolute {
+				return errors.New("TryFiles can contain at most one absolute path")
+			}
+			absolute = true
+			if _, exists := subfiles[strings.TrimPrefix(tryFile, "/")]; !exists {
+				return fmt.Errorf("absolute TryFiles path %v does not exist", tryFile)
+			}
+		}
+		tryFiles, err := json.Marshal(opts.TryFiles)
+		if err != nil {
+			return errors.AddContext(err, "could not marshal TryFiles")
+		}
+		values.Set("tryfiles", string(tryFiles))
 	}
 
-	// Only the first 512 bytes are used to sniff the content type.
-	buffer := make([]byte, 512)
-
-	_, err := file.Read(buffer)
-	if err != nil {
-		return "", err
+	if len(opts.ErrorPages) > 0 {
+		for code, page := range opts.ErrorPages {
+			if code < 400 || code > 599 {
+				return fmt.Errorf("ErrorPages can only be set for status codes between 400 and 599, not %v", code)
+			}
+			if !strings.HasPrefix(page, "/") {
+				return fmt.Errorf("error page %v for status code %v must be an absolute path", page, code)
+			}
+			if _, exists := subfiles[strings.TrimPrefix(page, "/")]; !exists {
+				return fmt.Errorf("error page %v for status code %v does not exist", page, code)
+			}
+		}
+		errorPages, err := json.Marshal(opts.ErrorPages)
+		if err != nil {
+			return errors.AddContext(err, "could not marshal ErrorPages")
+		}
+		values.Set("errorpages", string(errorPages))
 	}
-
-	// Always returns a valid content-type by returning
-	// "application/octet-stream" if no others seemed to match.
-	contentType = http.DetectContentType(buffer)
-
-	return contentType, nil
+	return nil
 }
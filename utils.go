@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	gopath "path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gitlab.com/NebulousLabs/errors"
 )
@@ -20,6 +23,30 @@ type (
 		Message string `json:"message"`
 	}
 
+	// dirFile is a file found while walking a directory.
+	dirFile struct {
+		// path is the path of the file on disk.
+		path string
+		// relpath is the slash-separated path of the file relative to the
+		// walked directory.
+		relpath string
+		size    int64
+		mode    os.FileMode
+		modTime time.Time
+		// linkTarget is the target of a symlink that is stored instead of
+		// followed.
+		linkTarget string
+	}
+
+	// dirWalker walks a directory for UploadDirectory.
+	dirWalker struct {
+		opts      UploadOptions
+		exclude   ignoreMatcher
+		include   ignoreMatcher
+		ancestors map[string]struct{}
+		files     []dirFile
+	}
+
 	// Options contains options used for connecting to a Skynet portal and
 	// endpoint.
 	Options struct {
@@ -29,6 +56,9 @@ type (
 
 		// APIKey is the API password to use for authentication.
 		APIKey string
+		// Keystore, if set, provides the API key of the portal if APIKey is
+		// not set.
+		Keystore *Keystore
 		// CustomUserAgent is the custom user agent to use.
 		CustomUserAgent string
 
@@ -49,6 +79,21 @@ const (
 var (
 	// ErrResponseError is the error for a response with a status code >= 400.
 	ErrResponseError = errors.New("error response")
+	// ErrNotFound is the error for a response with a 404 status code, which
+	// portals return when the requested resource doesn't exist. It is
+	// returned together with ErrResponseError.
+	ErrNotFound = errors.New("not found")
+	// ErrRequestTimeout is the error for a response with a 408 status code,
+	// which portals return when they couldn't complete a request in time. It
+	// is returned together with ErrResponseError.
+	ErrRequestTimeout = errors.New("request timeout")
+
+	// ErrFileTooLarge is the error for a file in a directory upload that is
+	// larger than UploadOptions.MaxFileSize.
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrFileChanged is the error for a file in a directory upload that
+	// changed or disappeared after the directory was walked.
+	ErrFileChanged = errors.New("file changed during upload")
 )
 
 // DefaultOptions returns the default options with the given endpoint path.
@@ -57,6 +102,7 @@ func DefaultOptions(endpointPath string) Options {
 		EndpointPath: endpointPath,
 
 		APIKey:          "",
+		Keystore:        nil,
 		CustomUserAgent: "",
 	}
 }
@@ -90,6 +136,12 @@ func makeResponseError(resp *http.Response) error {
 	}
 
 	context := fmt.Sprintf("%v response from %v: %v", resp.StatusCode, resp.Request.Method, message)
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.AddContext(errors.Compose(ErrResponseError, ErrNotFound), context)
+	}
+	if resp.StatusCode == http.StatusRequestTimeout {
+		return errors.AddContext(errors.Compose(ErrResponseError, ErrRequestTimeout), context)
+	}
 	return errors.AddContext(ErrResponseError, context)
 }
 
@@ -125,25 +177,169 @@ func parseResponseBody(resp *http.Response) (respBody *bytes.Buffer, err error)
 	return respBody, nil
 }
 
-// walkDirectory walks a given directory recursively, returning the paths of all
-// files found.
-func walkDirectory(path string) ([]string, error) {
-	var files []string
-	err := filepath.Walk(path, func(subpath string, info os.FileInfo, err error) error {
+// writeFileAtomic writes data to the file at path by writing it to a
+// temporary file first and renaming it, so that the file is never left
+// partially written.
+func writeFileAtomic(path string, data []byte) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.AddContext(err, "could not create temporary file")
+	}
+	defer func() {
 		if err != nil {
-			return err
+			err = errors.Compose(err, os.Remove(tmp.Name()))
 		}
-		if subpath == path {
-			return nil
+	}()
+	_, err = tmp.Write(data)
+	if err != nil {
+		return errors.Compose(errors.AddContext(err, "could not write temporary file"), tmp.Close())
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return errors.Compose(errors.AddContext(err, "could not sync temporary file"), tmp.Close())
+	}
+	err = tmp.Close()
+	if err != nil {
+		return errors.AddContext(err, "could not close temporary file")
+	}
+	return errors.AddContext(os.Rename(tmp.Name(), path), "could not rename temporary file")
+}
+
+// walkDirectory walks a given directory recursively, returning all files found
+// that are not filtered out by the given options. The files of each directory
+// are returned in lexical order.
+func walkDirectory(path string, opts UploadOptions) ([]dirFile, error) {
+	w := dirWalker{
+		opts:      opts,
+		ancestors: make(map[string]struct{}),
+	}
+	err := w.exclude.addPatterns(opts.ExcludePatterns)
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid exclude pattern")
+	}
+	err = w.include.addPatterns(opts.IncludePatterns)
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid include pattern")
+	}
+	err = w.walk(path, "")
+	if err != nil {
+		return []dirFile{}, err
+	}
+	return w.files, nil
+}
+
+// walk walks the directory dir, which is located at reldir relative to the
+// root of the walk.
+func (w *dirWalker) walk(dir, reldir string) error {
+	// Keep track of the directories we are in to detect symlink cycles.
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.AddContext(err, "could not resolve directory "+dir)
+	}
+	if _, exists := w.ancestors[realDir]; exists {
+		return nil
+	}
+	w.ancestors[realDir] = struct{}{}
+	defer delete(w.ancestors, realDir)
+
+	if w.opts.IgnoreFilename != "" {
+		err = w.exclude.addIgnoreFile(filepath.Join(dir, w.opts.IgnoreFilename), reldir)
+		if err != nil {
+			return errors.AddContext(err, "could not load ignore file in "+dir)
+		}
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		subpath := filepath.Join(dir, name)
+		relpath := gopath.Join(reldir, name)
+		if name == w.opts.IgnoreFilename {
+			continue
+		}
+		if w.opts.SkipHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		// Handle symlinks.
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch w.opts.Symlinks {
+			case SymlinkSkip:
+				continue
+			case SymlinkStore:
+				target, err := os.Readlink(subpath)
+				if err != nil {
+					return errors.AddContext(err, "could not read symlink "+subpath)
+				}
+				if w.excluded(relpath) {
+					continue
+				}
+				w.files = append(w.files, dirFile{
+					path:       subpath,
+					relpath:    relpath,
+					size:       int64(len(target)),
+					mode:       os.ModeSymlink | 0777,
+					linkTarget: target,
+				})
+				continue
+			default:
+				info, err = os.Stat(subpath)
+				if err != nil {
+					return errors.AddContext(err, "could not follow symlink "+subpath)
+				}
+			}
 		}
+
 		if info.IsDir() {
-			return nil
+			if w.exclude.match(relpath, true) {
+				continue
+			}
+			err = w.walk(subpath, relpath)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() || w.excluded(relpath) {
+			continue
+		}
+		if w.opts.MaxFileSize > 0 && info.Size() > w.opts.MaxFileSize {
+			return errors.AddContext(ErrFileTooLarge, fmt.Sprintf("file %v has %v bytes, the maximum is %v", subpath, info.Size(), w.opts.MaxFileSize))
+		}
+		w.files = append(w.files, dirFile{
+			path:    subpath,
+			relpath: relpath,
+			size:    info.Size(),
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+		})
+	}
+	return nil
+}
+
+// excluded returns whether the file at the given relative path is filtered
+// out by the exclude and include patterns.
+func (w *dirWalker) excluded(relpath string) bool {
+	if w.exclude.match(relpath, false) {
+		return true
+	}
+	return len(w.include.patterns) > 0 && !w.included(relpath)
+}
+
+// included returns whether the file at the given relative path or one of its
+// parent directories matches the include patterns, so that including a
+// directory includes the files in it.
+func (w *dirWalker) included(relpath string) bool {
+	if w.include.match(relpath, false) {
+		return true
+	}
+	for dir := gopath.Dir(relpath); dir != "."; dir = gopath.Dir(dir) {
+		if w.include.match(dir, true) {
+			return true
 		}
-		files = append(files, subpath)
-		return nil
-	})
-	if err != nil {
-		return []string{}, err
 	}
-	return files, nil
+	return false
 }
@@ -1,8 +1,14 @@
 package skynet
 
 import (
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
 )
 
 var (
@@ -69,7 +75,7 @@ func TestMakeURL(t *testing.T) {
 func TestWalkDirectory(t *testing.T) {
 	const testDir = "testdata"
 
-	files, err := walkDirectory(testDir)
+	files, err := walkDirectory(testDir, DefaultUploadOptions)
 	if err != nil {
 		t.Error(err)
 	}
@@ -85,8 +91,134 @@ func TestWalkDirectory(t *testing.T) {
 		t.Errorf("expected %v files, got %v", len(expectedFiles), len(files))
 	}
 	for i, f := range files {
-		if f != expectedFiles[i] {
-			t.Errorf("file %s at index %d != expected file %s at same index", f, i, expectedFiles[i])
+		if f.path != expectedFiles[i] {
+			t.Errorf("file %s at index %d != expected file %s at same index", f.path, i, expectedFiles[i])
+		}
+	}
+}
+
+// TestWalkDirectoryFilters tests filtering files while walking a directory.
+func TestWalkDirectoryFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a tree of files.
+	files := map[string]string{
+		".git/HEAD":                "ref",
+		".skynetignore":            "node_modules/\n*.swp\n",
+		"index.html":               "<html></html>",
+		".index.html.swp":          "swap",
+		"node_modules/dep/main.js": "dep",
+		"src/app.js":               "app",
+		"src/.skynetignore":        "*.map\n",
+		"src/app.js.map":           "{}",
+		"big.bin":                  "0123456789",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
 		}
 	}
+	// Link to a directory, and a link back to the root which would cause a
+	// cycle.
+	if err := os.Symlink(filepath.Join(dir, "src"), filepath.Join(dir, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(dir, "src", "root")); err != nil {
+		t.Fatal(err)
+	}
+
+	relpaths := func(files []dirFile) []string {
+		paths := make([]string, 0, len(files))
+		for _, f := range files {
+			paths = append(paths, f.relpath)
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(opts *UploadOptions)
+		expected []string
+	}{
+		{
+			name:     "default",
+			modify:   func(opts *UploadOptions) {},
+			expected: []string{".git/HEAD", "big.bin", "index.html", "lib/app.js", "src/app.js"},
+		},
+		{
+			name: "no ignore file",
+			modify: func(opts *UploadOptions) {
+				opts.IgnoreFilename = ""
+				opts.Symlinks = SymlinkSkip
+			},
+			expected: []string{".git/HEAD", ".index.html.swp", ".skynetignore", "big.bin", "index.html", "node_modules/dep/main.js", "src/.skynetignore", "src/app.js", "src/app.js.map"},
+		},
+		{
+			name: "skip hidden and exclude",
+			modify: func(opts *UploadOptions) {
+				opts.SkipHidden = true
+				opts.ExcludePatterns = []string{"*.bin", "/lib"}
+			},
+			expected: []string{"index.html", "src/app.js"},
+		},
+		{
+			name: "include",
+			modify: func(opts *UploadOptions) {
+				opts.IncludePatterns = []string{"*.js"}
+				opts.Symlinks = SymlinkSkip
+			},
+			expected: []string{"src/app.js"},
+		},
+		{
+			name: "include directory",
+			modify: func(opts *UploadOptions) {
+				opts.IncludePatterns = []string{"src/"}
+			},
+			expected: []string{"src/app.js"},
+		},
+		{
+			name: "include directory or file",
+			modify: func(opts *UploadOptions) {
+				opts.IncludePatterns = []string{"src", "index.html"}
+				opts.IgnoreFilename = ""
+				opts.Symlinks = SymlinkSkip
+			},
+			expected: []string{"index.html", "src/.skynetignore", "src/app.js", "src/app.js.map"},
+		},
+		{
+			name: "store symlinks",
+			modify: func(opts *UploadOptions) {
+				opts.Symlinks = SymlinkStore
+				opts.SkipHidden = true
+			},
+			expected: []string{"big.bin", "index.html", "lib", "src/app.js", "src/root"},
+		},
+	}
+	for _, test := range tests {
+		opts := DefaultUploadOptions
+		test.modify(&opts)
+		files, err := walkDirectory(dir, opts)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if paths := relpaths(files); !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, paths)
+		}
+	}
+
+	// Test the max file size guard.
+	opts := DefaultUploadOptions
+	opts.MaxFileSize = 5
+	_, err = walkDirectory(dir, opts)
+	if !errors.Contains(err, ErrFileTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrFileTooLarge, err)
+	}
 }